@@ -0,0 +1,259 @@
+// Package bridge mirrors topics from one Kafka cluster to another, for example from an edge device to the cloud.
+//
+// Every mirrored message carries this instance in its x-trace header. Messages that already carry it are skipped,
+// so a pair of bridges mirroring edge to cloud and cloud to edge does not send messages back and forth forever.
+// The offset of a source message is only marked once the destination acknowledged its copy, so a crash or a failed
+// delivery redelivers it instead of losing it.
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/consumer/raw"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/producer"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+	"go.uber.org/zap"
+	"regexp"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// Source delivers the messages to mirror, it is implemented by raw.Consumer.
+type Source interface {
+	GetMessages() <-chan *shared.KafkaMessage
+	MarkMessage(message *shared.KafkaMessage)
+}
+
+// Destination produces the mirrored messages, it is implemented by producer.Producer.
+type Destination interface {
+	SendMessageAsync(ctx context.Context, message *shared.KafkaMessage, callback shared.DeliveryCallback) error
+}
+
+var (
+	_ Source      = (*raw.Consumer)(nil)
+	_ Destination = (*producer.Producer)(nil)
+)
+
+// Rule mirrors the topics matching Pattern to the topic Template renders.
+type Rule struct {
+	// Pattern is the regular expression source topics must match in full.
+	Pattern string
+	// Template renders the destination topic with text/template, it defaults to "{{.Topic}}".
+	// It is executed with a TopicData, for example "umh.v1.cloud.{{index .Match 1}}" or "{{.Named.site}}.mirror".
+	Template string
+}
+
+// TopicData is passed to the Template of a Rule.
+type TopicData struct {
+	// Topic is the source topic.
+	Topic string
+	// Match holds the source topic followed by the submatches of the pattern.
+	Match []string
+	// Named holds the named submatches of the pattern.
+	Named map[string]string
+}
+
+// Config configures a Bridge.
+type Config struct {
+	// Rules select the topics to mirror and name their destination topics. The first matching rule is used.
+	Rules []Rule
+	// SkipSameOrigin skips messages whose x-origin is this instance. Enable it on the bridge mirroring back to the
+	// instance that produced the messages originally.
+	SkipSameOrigin bool
+	// Trace bounds and encodes the x-trace header of mirrored messages, it defaults to shared.DefaultTraceOptions.
+	// Set it to the options of the destination producer, see shared.WithTraceLimits and shared.WithTraceEncoding.
+	Trace shared.TraceOptions
+	// Logger receives the logs of the bridge, it defaults to the global zap logger.
+	Logger *zap.Logger
+}
+
+// Patterns returns the patterns of all rules, the source consumer must subscribe to them.
+func (c Config) Patterns() []string {
+	patterns := make([]string, 0, len(c.Rules))
+	for _, rule := range c.Rules {
+		patterns = append(patterns, rule.Pattern)
+	}
+	return patterns
+}
+
+type rule struct {
+	pattern  *regexp.Regexp
+	template *template.Template
+}
+
+// Bridge mirrors messages from a Source to a Destination.
+type Bridge struct {
+	source         Source
+	destination    Destination
+	rules          []rule
+	skipSameOrigin bool
+	trace          shared.TraceOptions
+	mirrored       atomic.Uint64
+	skipped        atomic.Uint64
+	log            *zap.SugaredLogger
+}
+
+// New returns a Bridge mirroring messages from source to destination.
+func New(source Source, destination Destination, config Config) (*Bridge, error) {
+	if source == nil {
+		return nil, errors.New("source must not be nil")
+	}
+	if destination == nil {
+		return nil, errors.New("destination must not be nil")
+	}
+	if len(config.Rules) == 0 {
+		return nil, errors.New("at least one rule is required")
+	}
+	if config.Logger == nil {
+		config.Logger = zap.L()
+	}
+	if config.Trace == (shared.TraceOptions{}) {
+		config.Trace = shared.DefaultTraceOptions
+	}
+
+	b := &Bridge{
+		source:         source,
+		destination:    destination,
+		skipSameOrigin: config.SkipSameOrigin,
+		trace:          config.Trace,
+		log:            config.Logger.Sugar(),
+	}
+	for _, r := range config.Rules {
+		pattern, err := regexp.Compile("^(?:" + r.Pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", r.Pattern, err)
+		}
+		text := r.Template
+		if text == "" {
+			text = "{{.Topic}}"
+		}
+		tmpl, err := template.New(r.Pattern).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %q: %w", text, err)
+		}
+		b.rules = append(b.rules, rule{pattern: pattern, template: tmpl})
+	}
+	return b, nil
+}
+
+// Topic returns the destination topic of topic, false if no rule matches it,
+// or an error if the template of the matching rule cannot be rendered.
+func (b *Bridge) Topic(topic string) (string, bool, error) {
+	for _, r := range b.rules {
+		match := r.pattern.FindStringSubmatch(topic)
+		if match == nil {
+			continue
+		}
+		data := TopicData{
+			Topic: topic,
+			Match: match,
+			Named: make(map[string]string),
+		}
+		for i, name := range r.pattern.SubexpNames() {
+			if name != "" {
+				data.Named[name] = match[i]
+			}
+		}
+		var buffer bytes.Buffer
+		if err := r.template.Execute(&buffer, data); err != nil {
+			return "", true, fmt.Errorf("failed to render the destination topic of %s: %w", topic, err)
+		}
+		if buffer.Len() == 0 {
+			return "", true, fmt.Errorf("empty destination topic for %s", topic)
+		}
+		return buffer.String(), true, nil
+	}
+	return "", false, nil
+}
+
+// Run mirrors messages until ctx is done, the source channel is closed or a message could not be delivered.
+// It returns ctx.Err(), nil, or the delivery error. Messages that were not acknowledged stay unmarked,
+// so they are redelivered once the source consumer is restarted.
+func (b *Bridge) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	messages := b.source.GetMessages()
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case message, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			if err := b.mirror(ctx, message, cancel); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// mirror sends a copy of message to the destination, the source message is marked once the copy is acknowledged.
+func (b *Bridge) mirror(ctx context.Context, message *shared.KafkaMessage, fail context.CancelCauseFunc) error {
+	if message == nil {
+		return nil
+	}
+	if shared.IsInTrace(message) || (b.skipSameOrigin && shared.IsSameOrigin(message)) {
+		b.log.Debugf("skipping message %s/%d@%d, it passed this instance before", message.Topic, message.Partition, message.Offset)
+		b.skip(message)
+		return nil
+	}
+	topic, ok, err := b.Topic(message.Topic)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		b.log.Warnf("skipping message of %s, no rule matches the topic", message.Topic)
+		b.skip(message)
+		return nil
+	}
+
+	mirrored := &shared.KafkaMessage{
+		Topic:   topic,
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: make(map[string]string, len(message.Headers)+1),
+		Context: message.Context,
+	}
+	for k, v := range message.Headers {
+		mirrored.Headers[k] = v
+	}
+	// The producer keeps the hops of an existing x-trace header without adding its own.
+	if err = b.trace.AddSXTrace(mirrored); err != nil {
+		return err
+	}
+
+	callback := func(report shared.DeliveryReport) {
+		if report.Err != nil {
+			fail(fmt.Errorf("failed to mirror %s/%d@%d to %s: %w", message.Topic, message.Partition, message.Offset, topic, report.Err))
+			return
+		}
+		b.mirrored.Add(1)
+		b.source.MarkMessage(message)
+	}
+	for {
+		err = b.destination.SendMessageAsync(ctx, mirrored, callback)
+		if !errors.Is(err, shared.ErrQueueFull) {
+			return err
+		}
+		// Wait for acknowledgements to free in-flight slots.
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(shared.CycleTime):
+		}
+	}
+}
+
+func (b *Bridge) skip(message *shared.KafkaMessage) {
+	b.skipped.Add(1)
+	b.source.MarkMessage(message)
+}
+
+// GetStats returns the number of mirrored and skipped messages.
+func (b *Bridge) GetStats() (uint64, uint64) {
+	return b.mirrored.Load(), b.skipped.Load()
+}
@@ -0,0 +1,152 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/producer"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSource struct {
+	messages chan *shared.KafkaMessage
+	mutex    sync.Mutex
+	marked   []*shared.KafkaMessage
+}
+
+func (s *fakeSource) GetMessages() <-chan *shared.KafkaMessage {
+	return s.messages
+}
+
+func (s *fakeSource) MarkMessage(message *shared.KafkaMessage) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.marked = append(s.marked, message)
+}
+
+func (s *fakeSource) Marked() []*shared.KafkaMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]*shared.KafkaMessage(nil), s.marked...)
+}
+
+type pendingDelivery struct {
+	message  *shared.KafkaMessage
+	callback producer.DeliveryCallback
+}
+
+type fakeDestination struct {
+	deliveries chan pendingDelivery
+}
+
+func (d *fakeDestination) SendMessageAsync(_ context.Context, message *shared.KafkaMessage, callback producer.DeliveryCallback) error {
+	d.deliveries <- pendingDelivery{message: message, callback: callback}
+	return nil
+}
+
+func TestBridgeMirrorsAfterAcknowledgement(t *testing.T) {
+	source := &fakeSource{messages: make(chan *shared.KafkaMessage, 10)}
+	destination := &fakeDestination{deliveries: make(chan pendingDelivery, 10)}
+	b, err := New(source, destination, Config{Rules: []Rule{{
+		Pattern:  `umh\.v1\.(?P<site>[a-z]+)\.(.*)`,
+		Template: "umh.v1.cloud.{{.Named.site}}.{{index .Match 2}}",
+	}}})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Run(ctx)
+	}()
+
+	// A message that was mirrored by this instance before is skipped.
+	trace, err := json.Marshal(shared.TraceValue{Traces: map[int64]string{1: "-"}})
+	assert.NoError(t, err)
+	looped := &shared.KafkaMessage{Topic: "umh.v1.edge.temperature", Headers: map[string]string{"x-trace": string(trace)}}
+	source.messages <- looped
+
+	message := &shared.KafkaMessage{Topic: "umh.v1.edge.temperature", Key: []byte("k"), Value: []byte("23.5"), Headers: map[string]string{"x-origin": "edge"}}
+	source.messages <- message
+	delivery := <-destination.deliveries
+	assert.Equal(t, "umh.v1.cloud.edge.temperature", delivery.message.Topic)
+	assert.Equal(t, []byte("23.5"), delivery.message.Value)
+	assert.Equal(t, "edge", delivery.message.Headers["x-origin"])
+	assert.True(t, shared.IsInTrace(delivery.message))
+	assert.Equal(t, []*shared.KafkaMessage{looped}, source.Marked(), "the message is only marked once it was acknowledged")
+
+	delivery.callback(producer.DeliveryReport{})
+	assert.Equal(t, []*shared.KafkaMessage{looped, message}, source.Marked())
+	mirrored, skipped := b.GetStats()
+	assert.Equal(t, uint64(1), mirrored)
+	assert.Equal(t, uint64(1), skipped)
+
+	source.messages <- &shared.KafkaMessage{Topic: "umh.v1.edge.pressure"}
+	delivery = <-destination.deliveries
+	delivery.callback(producer.DeliveryReport{Err: errors.New("boom")})
+	select {
+	case err = <-done:
+		assert.ErrorContains(t, err, "boom")
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to stop after a failed delivery")
+	}
+	assert.Len(t, source.Marked(), 2)
+}
+
+func TestBridgeBoundsTraceWithConfiguredOptions(t *testing.T) {
+	source := &fakeSource{messages: make(chan *shared.KafkaMessage, 1)}
+	destination := &fakeDestination{deliveries: make(chan pendingDelivery, 1)}
+	b, err := New(source, destination, Config{
+		Rules: []Rule{{Pattern: `umh\.v1\..*`}},
+		Trace: shared.TraceOptions{MaxHops: 3, Encoding: shared.TraceEncodingBinary},
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = b.Run(ctx)
+	}()
+
+	hops := make(map[int64]string)
+	for i := int64(1); i <= 10; i++ {
+		hops[i] = "edge-service"
+	}
+	trace, err := json.Marshal(shared.TraceValue{Traces: hops})
+	assert.NoError(t, err)
+	source.messages <- &shared.KafkaMessage{Topic: "umh.v1.edge.temperature", Headers: map[string]string{"x-trace": string(trace)}}
+
+	delivery := <-destination.deliveries
+	assert.True(t, strings.HasPrefix(delivery.message.Headers["x-trace"], "b1:"), "the trace is binary encoded")
+	assert.Len(t, delivery.message.TraceHops(), 3)
+	assert.True(t, shared.IsInTrace(delivery.message))
+}
+
+func TestBridgeTopic(t *testing.T) {
+	b, err := New(&fakeSource{}, &fakeDestination{}, Config{Rules: []Rule{
+		{Pattern: `orders`},
+		{Pattern: `umh\..*`, Template: "mirror.{{.Topic}}"},
+	}})
+	assert.NoError(t, err)
+
+	topic, ok, err := b.Topic("umh.v1.orders")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "mirror.umh.v1.orders", topic)
+
+	topic, ok, err = b.Topic("orders")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "orders", topic)
+
+	_, ok, _ = b.Topic("orders.dlq")
+	assert.False(t, ok, "patterns must match the whole topic")
+
+	_, err = New(&fakeSource{}, &fakeDestination{}, Config{Rules: []Rule{{Pattern: "(", Template: ""}}})
+	assert.Error(t, err)
+}
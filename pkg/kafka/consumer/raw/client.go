@@ -2,11 +2,13 @@ package raw
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/IBM/sarama"
 	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
 	"go.uber.org/zap"
 	"regexp"
-	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -22,6 +24,24 @@ const (
 	ConsumerStateDead
 )
 
+// String returns the name the broker uses for the state.
+func (s ConsumerState) String() string {
+	switch s {
+	case ConsumerStateEmpty:
+		return "Empty"
+	case ConsumerStateStable:
+		return "Stable"
+	case ConsumerStatePreparingRebalance:
+		return "PreparingRebalance"
+	case ConsumerStateCompletingRebalance:
+		return "CompletingRebalance"
+	case ConsumerStateDead:
+		return "Dead"
+	default:
+		return "Unknown"
+	}
+}
+
 // Consumer wraps sarama's ConsumerGroup.
 type Consumer struct {
 	consumerGroup         *sarama.ConsumerGroup
@@ -37,60 +57,110 @@ type Consumer struct {
 	internalCtx           context.Context
 	rawClient             sarama.Client
 	groupName             string
-	groupState            ConsumerState
+	groupState            atomic.Int32
 	externalCtx           context.Context
 	runConsumerGroup      atomic.Bool
 	consuming             atomic.Bool
+	ready                 atomic.Bool
+	config                *shared.Config
+	offsets               *shared.OffsetTracker
+	seeker                *shared.Seeker
+	pauser                *shared.Pauser
+	closeOnce             sync.Once
+	closeErr              error
+	done                  chan struct{}
+	unregisterMetrics     []func()
+	errors                chan error
+	stateMutex            sync.Mutex
+	stateErr              error
+	terminalErr           error
+	activity              shared.Activity
+	log                   *zap.SugaredLogger
 }
 
+// ErrConsumerClosed is returned when using a consumer that has been closed.
+var ErrConsumerClosed = errors.New("consumer closed")
+
+// errorBufferSize is the capacity of the Errors channel, further errors are dropped until it is drained.
+const errorBufferSize = 16
+
 // NewConsumer initializes a Consumer.
-func NewConsumer(brokers, topic []string, groupName string, instanceId string) (*Consumer, error) {
-	zap.S().Infof("connecting to brokers: %v", brokers)
-	config := sarama.NewConfig()
-	config.Consumer.Offsets.Initial = sarama.OffsetOldest
-	config.Consumer.Group.InstanceId = instanceId
-	config.Version = sarama.V2_3_0_0
-
-	c, err := sarama.NewClient(brokers, config)
+func NewConsumer(brokers, topic []string, groupName string, instanceId string, opts ...shared.Option) (*Consumer, error) {
+	defaults := sarama.NewConfig()
+	defaults.Consumer.Offsets.Initial = sarama.OffsetOldest
+	defaults.Consumer.Group.InstanceId = instanceId
+	defaults.Version = sarama.V2_3_0_0
+
+	config, err := shared.NewConfig(shared.Config{
+		Sarama:         defaults,
+		CommitInterval: 10 * time.Second,
+	}, opts...)
 	if err != nil {
 		return nil, err
 	}
-	zap.S().Infof("connected to brokers: %v", brokers)
+	// Invalid topic patterns are rejected before connecting, so no client is left behind.
+	var rgxTopics []regexp.Regexp
+	for _, t := range topic {
+		rgx, err := regexp.Compile(t)
+		if err != nil {
+			return nil, err
+		}
+		rgxTopics = append(rgxTopics, *rgx)
+	}
+
+	log := config.Logger.Sugar().With("group_id", groupName)
+	log.Infof("connecting to brokers: %v", brokers)
+
+	c, err := sarama.NewClient(brokers, config.Sarama)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("connected to brokers: %v", brokers)
 	err = c.RefreshMetadata()
 	if err != nil {
+		_ = c.Close()
 		return nil, err
 	}
-	zap.S().Info("Refreshed metadata")
+	log.Info("Refreshed metadata")
 
 	var cg sarama.ConsumerGroup
 	cg, err = sarama.NewConsumerGroupFromClient(groupName, c)
 	if err != nil {
+		_ = c.Close()
 		return nil, err
 	}
 
-	var rgxTopics []regexp.Regexp
-	for _, t := range topic {
-		rgx, err := regexp.Compile(t)
-		if err != nil {
-			return nil, err
-		}
-		rgxTopics = append(rgxTopics, *rgx)
-	}
-
-	return &Consumer{
+	consumer := &Consumer{
 		brokers:          brokers,
 		regexTopics:      rgxTopics,
 		consumerGroup:    &cg,
 		rawClient:        c,
-		incomingMessages: make(chan *shared.KafkaMessage, 100_000),
-		messagesToMark:   make(chan *shared.KafkaMessage, 100_000),
+		incomingMessages: make(chan *shared.KafkaMessage, config.ChannelBufferSize),
+		messagesToMark:   make(chan *shared.KafkaMessage, config.ChannelBufferSize),
 		running:          atomic.Bool{},
 		runConsumerGroup: atomic.Bool{},
 		groupName:        groupName,
-		groupState:       ConsumerStateUnknown,
-	}, nil
+		config:           config,
+		done:             make(chan struct{}),
+		offsets:          shared.NewOffsetTracker(),
+		seeker:           shared.NewSeeker(),
+		pauser:           shared.NewPauser(),
+		errors:           make(chan error, errorBufferSize),
+		log:              log,
+	}
+	consumer.unregisterMetrics = []func(){
+		config.Metrics.RegisterChannel(groupName, "incoming", func() (int, int) {
+			return len(consumer.incomingMessages), cap(consumer.incomingMessages)
+		}),
+		config.Metrics.RegisterChannel(groupName, "to_mark", func() (int, int) {
+			return len(consumer.messagesToMark), cap(consumer.messagesToMark)
+		}),
+		config.Metrics.RegisterSaramaRegistry(config.Sarama.ClientID, config.Sarama.MetricRegistry),
+	}
+	return consumer, nil
 }
 
+// GetTopics returns the topics that the consumer is subscribed to.
 func (c *Consumer) GetTopics() []string {
 	return c.actualTopics
 }
@@ -115,43 +185,63 @@ func (c *Consumer) Start(ctx context.Context) error {
 func (c *Consumer) consume() {
 	alreadyConsuming := c.consuming.Swap(true)
 	if alreadyConsuming {
-		zap.S().Fatalf("consume called while already consuming")
+		// The running loop keeps consuming, a second one would join the group twice.
+		c.log.Warnf("consume called while already consuming")
+		return
 	}
+	attempt := 0
 	for c.running.Load() {
+		if len(c.actualTopics) == 0 {
+			c.log.Info("no topics to consume, trying later")
+			time.Sleep(shared.CycleTime * 10)
+			continue
+		}
 		handler := &GroupHandler{
 			incomingMessages: c.incomingMessages,
 			messagesToMark:   c.messagesToMark,
 			running:          &c.runConsumerGroup,
+			ready:            &c.ready,
 			markedMessages:   &c.markedMessages,
 			consumedMessages: &c.consumedMessages,
+			commitInterval:   c.config.CommitInterval,
+			config:           c.config,
+			offsets:          c.offsets,
+			groupName:        c.groupName,
+			client:           c.rawClient,
+			seeker:           c.seeker,
+			pauser:           c.pauser,
+			group:            *c.consumerGroup,
+			activity:         &c.activity,
+			log:              c.log,
 		}
+		c.log.Infof("starting consumer with topics %v", c.actualTopics)
 
-		zap.S().Infof("starting consumer with topics %v", c.actualTopics)
-
-		if err := (*c.consumerGroup).Consume(c.internalCtx, c.actualTopics, handler); err != nil {
-			// Check if the error is "no topics provided"
-			if err.Error() == "no topics provided" {
-				zap.S().Info("no topics provided")
-				time.Sleep(shared.CycleTime * 10)
-				continue
-			} else if strings.Contains(err.Error(), "i/o timeout") {
-				zap.S().Info("i/o timeout, trying later")
-				time.Sleep(shared.CycleTime * 10)
-				continue
-			} else if strings.Contains(err.Error(), "context canceled") {
-				zap.S().Info("context canceled, trying later")
-				time.Sleep(shared.CycleTime * 10)
-				continue
-			} else if strings.Contains(err.Error(), "EOF") {
-				zap.S().Info("EOF, trying later")
-				time.Sleep(shared.CycleTime * 10)
-				continue
-			}
-			c.running.Store(false)
-			zap.S().Error(err)
+		err := (*c.consumerGroup).Consume(c.internalCtx, c.actualTopics, handler)
+		if err == nil {
+			attempt = 0
+			continue
+		}
+		if !c.running.Load() {
+			// Close or a topic change stopped the consumer, errors of the interrupted session are expected.
+			c.log.Debugf("consumer stopped with %s", err)
+			break
 		}
+		classified := shared.NewClassifiedError(err)
+		if classified.Class == shared.ErrorClassRetriable {
+			delay := c.config.Backoff.Delay(attempt)
+			attempt++
+			c.log.Infof("%s, retrying in %s", err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		c.log.Errorf("stopping consumer: %s", classified)
+		c.running.Store(false)
+		c.stateMutex.Lock()
+		c.terminalErr = classified
+		c.stateMutex.Unlock()
+		c.reportError(classified)
 	}
-	zap.S().Infof("stopped consumer")
+	c.log.Infof("stopped consumer")
 	c.consuming.Store(false)
 }
 
@@ -174,16 +264,18 @@ func (c *Consumer) check() error {
 }
 
 func (c *Consumer) recheck() {
-	zap.S().Infof("starting recheck")
+	c.log.Infof("starting recheck")
 
 	var topics []string
 	var err error
 	for !c.rawClient.Closed() {
 		topics, err = c.rawClient.Topics()
 		if err != nil {
+			c.log.Warnf("failed to get topics: %s", err)
+			time.Sleep(shared.CycleTime * 10)
 			continue
 		}
-		zap.S().Debugf("client has %v", topics)
+		c.log.Debugf("client has %v", topics)
 		var newTopics []string
 		for _, name := range topics {
 			for _, rgx := range c.regexTopics {
@@ -196,7 +288,7 @@ func (c *Consumer) recheck() {
 
 		var changed bool
 		if len(newTopics) != len(c.actualTopics) {
-			zap.S().Infof("topics changed [fast] %v -> %v", c.actualTopics, newTopics)
+			c.log.Infof("topics changed [fast] %v -> %v", c.actualTopics, newTopics)
 			changed = true
 		} else {
 			for i := range newTopics {
@@ -209,21 +301,24 @@ func (c *Consumer) recheck() {
 				}
 				if !found {
 					changed = true
-					zap.S().Infof("topics changed [slow] %v -> %v", c.actualTopics, newTopics)
+					c.log.Infof("topics changed [slow] %v -> %v", c.actualTopics, newTopics)
 					break
 				}
 			}
 		}
+		if changed && c.stoppedByTerminalError() {
+			// The terminal error was reported on Errors, the consumer stays stopped instead of coming back silently.
+			c.log.Warnf("topics changed from %v to %v, but the consumer stopped after a terminal error", c.actualTopics, newTopics)
+			c.actualTopics = newTopics
+			changed = false
+		}
 		if changed {
-			zap.S().Infof("topics changed from %v to %v", c.actualTopics, newTopics)
+			c.log.Infof("topics changed from %v to %v", c.actualTopics, newTopics)
 			c.running.Store(false)
 			c.consumerContextCancel()
-			if err != nil {
-				zap.S().Fatal(err)
-			}
 			for c.consuming.Load() {
 				time.Sleep(shared.CycleTime * 10)
-				zap.S().Debugf("waiting for consumer to stop")
+				c.log.Debugf("waiting for consumer to stop")
 			}
 			// Wait for the consumer to stop
 			time.Sleep(shared.CycleTime * 10)
@@ -231,21 +326,72 @@ func (c *Consumer) recheck() {
 			c.actualTopics = newTopics
 			c.internalCtx, c.consumerContextCancel = context.WithCancel(c.externalCtx)
 			go c.consume()
-			zap.S().Infof("restarted consumer with topics %v", c.actualTopics)
+			c.log.Infof("restarted consumer with topics %v", c.actualTopics)
 		}
 		_ = c.rawClient.RefreshMetadata()
 		time.Sleep(shared.CycleTime * 50)
 	}
-	zap.S().Infof("stopped recheck")
+	c.log.Infof("stopped recheck")
 }
 
-// Close terminates the Consumer.
-func (c *Consumer) Close() error {
-	if !c.running.Swap(false) {
-		return nil
+// stoppedByTerminalError returns whether the consume loop stopped after a terminal error.
+func (c *Consumer) stoppedByTerminalError() bool {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return c.terminalErr != nil
+}
+
+// Close terminates the Consumer, leaves the consumer group and closes the client.
+// It returns ctx.Err() if ctx is done before the consume loop stopped, in which case the shutdown continues in the background.
+func (c *Consumer) Close(ctx context.Context) error {
+	c.closeOnce.Do(func() {
+		c.running.Store(false)
+		c.ready.Store(false)
+		if c.consumerContextCancel != nil {
+			c.consumerContextCancel()
+		}
+		c.closeErr = (*c.consumerGroup).Close()
+		if err := c.rawClient.Close(); err != nil && !errors.Is(err, sarama.ErrClosedClient) {
+			c.closeErr = errors.Join(c.closeErr, err)
+		}
+		go func() {
+			for c.consuming.Load() {
+				time.Sleep(shared.CycleTime)
+			}
+			for _, unregister := range c.unregisterMetrics {
+				unregister()
+			}
+			close(c.done)
+		}()
+	})
+
+	select {
+	case <-c.done:
+		return c.closeErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel that is closed once the consumer has been closed completely.
+func (c *Consumer) Done() <-chan struct{} {
+	return c.done
+}
+
+// Errors returns a channel of the errors that stopped the consumer, wrapped in a *shared.ClassifiedError.
+// Retriable errors are retried with the configured backoff and not reported.
+// The channel is never closed, select on Done as well to notice the end of the consumer.
+func (c *Consumer) Errors() <-chan error {
+	return c.errors
+}
+
+// reportError passes err to the Errors channel without blocking.
+func (c *Consumer) reportError(err error) {
+	select {
+	case c.errors <- err:
+	default:
+		c.log.Warnf("error channel full, dropping %s", err)
 	}
-	c.consumerContextCancel()
-	return (*c.consumerGroup).Close()
 }
 
 // IsRunning returns the run state.
@@ -253,86 +399,311 @@ func (c *Consumer) IsRunning() bool {
 	return c.running.Load()
 }
 
-// GetMessage receives a single message.
-func (c *Consumer) GetMessage() *shared.KafkaMessage {
+// IsReady returns whether the consumer has joined the group and is consuming its claims.
+func (c *Consumer) IsReady() bool {
+	return c.ready.Load()
+}
+
+// GetMessage blocks until a message is available and returns it.
+// It returns ctx.Err() if ctx is done first, or ErrConsumerClosed once the consumer is closed.
+func (c *Consumer) GetMessage(ctx context.Context) (*shared.KafkaMessage, error) {
 	select {
 	case msg := <-c.incomingMessages:
-		return msg
-	default:
-		return nil
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, ErrConsumerClosed
 	}
 }
 
+// Run passes consumed messages to handler on a pool of workers and marks them if handler returns nil.
+// It returns once ctx is done or the consumer is closed, see shared.Run for the ordering guarantees.
+// Every call of handler is traced in a process span continuing the trace of the message.
+func (c *Consumer) Run(ctx context.Context, handler shared.Handler, opts shared.RunOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return shared.Run(ctx, c.incomingMessages, c.MarkMessage, c.config.TraceHandler(handler), opts)
+}
+
 // GetMessages returns the message channel.
-func (c *Consumer) GetMessages() chan *shared.KafkaMessage {
+func (c *Consumer) GetMessages() <-chan *shared.KafkaMessage {
 	return c.incomingMessages
 }
 
 // MarkMessage marks a message for commit.
+// Messages marked after the consumer has been closed are dropped.
+// Offsets are committed up to the oldest unmarked message of the partition, so a consumed message that is never
+// marked stops the commits of its partition until the next session, see GetOffsetStats.
 func (c *Consumer) MarkMessage(msg *shared.KafkaMessage) {
-	c.messagesToMark <- msg
+	select {
+	case c.messagesToMark <- msg:
+	case <-c.done:
+	}
 }
 
 // MarkMessages marks multiple messages for commit.
 func (c *Consumer) MarkMessages(msgs []*shared.KafkaMessage) {
 	for _, msg := range msgs {
-		c.messagesToMark <- msg
+		c.MarkMessage(msg)
 	}
 }
 
+// GroupName returns the name of the consumer group.
+func (c *Consumer) GroupName() string {
+	return c.groupName
+}
+
+// CommitTransaction atomically produces messages with the transactional producer p and commits the offsets after
+// consumed for the consumer group. Once the transaction committed, consumed are marked, so later commits of this consumer keep their offsets.
+// consumed should be a contiguous batch per partition, since the transaction commits past messages that are still in flight.
+func (c *Consumer) CommitTransaction(ctx context.Context, p shared.TransactionalProducer, messages []*shared.KafkaMessage, consumed []*shared.KafkaMessage) error {
+	if err := p.SendMessagesWithOffsets(ctx, messages, c.groupName, consumed); err != nil {
+		return err
+	}
+	c.MarkMessages(consumed)
+	return nil
+}
+
+// SeekToOffset makes the consumer continue at offset on the partition, once it is assigned next.
+// The current session ends, so assigned partitions are repositioned right away.
+func (c *Consumer) SeekToOffset(topic string, partition int32, offset int64) {
+	c.seeker.SeekToOffset(topic, partition, offset)
+}
+
+// SeekToBeginning makes the consumer continue at the oldest retained message of the partition, see SeekToOffset.
+func (c *Consumer) SeekToBeginning(topic string, partition int32) {
+	c.seeker.SeekToBeginning(topic, partition)
+}
+
+// SeekToEnd makes the consumer skip all messages currently in the partition, see SeekToOffset.
+func (c *Consumer) SeekToEnd(topic string, partition int32) {
+	c.seeker.SeekToEnd(topic, partition)
+}
+
+// SeekToTimestamp makes the consumer continue at the first message of the partition with a timestamp at or after t,
+// see SeekToOffset.
+func (c *Consumer) SeekToTimestamp(topic string, partition int32, t time.Time) {
+	c.seeker.SeekToTimestamp(topic, partition, t)
+}
+
+// SeekAllToTimestamp applies SeekToTimestamp to every partition of the next assignment,
+// for example to replay the last hour with SeekAllToTimestamp(time.Now().Add(-time.Hour)).
+func (c *Consumer) SeekAllToTimestamp(t time.Time) {
+	c.seeker.SeekAllToTimestamp(t)
+}
+
+// Pause stops fetching from the given partitions without leaving the consumer group,
+// an empty partition list pauses the whole topic. Messages that were already fetched are still delivered.
+// Pauses stay in place across rebalances until they are lifted by Resume or ResumeAll.
+func (c *Consumer) Pause(partitions map[string][]int32) {
+	c.pauser.Pause(partitions)
+	c.pauser.Sync(*c.consumerGroup)
+}
+
+// Resume lifts pauses of the given partitions, an empty partition list lifts all pauses of the topic.
+func (c *Consumer) Resume(partitions map[string][]int32) {
+	c.pauser.Resume(partitions)
+	c.pauser.Sync(*c.consumerGroup)
+}
+
+// PauseAll stops fetching from all partitions, including partitions assigned later, see Pause.
+func (c *Consumer) PauseAll() {
+	c.pauser.PauseAll()
+	c.pauser.Sync(*c.consumerGroup)
+}
+
+// ResumeAll lifts all pauses.
+func (c *Consumer) ResumeAll() {
+	c.pauser.ResumeAll()
+	c.pauser.Sync(*c.consumerGroup)
+}
+
+// Paused returns the paused partitions of the current session.
+func (c *Consumer) Paused() map[string][]int32 {
+	return c.pauser.Paused()
+}
+
+// IsPaused returns whether the partition is paused, regardless of whether it is currently assigned.
+func (c *Consumer) IsPaused(topic string, partition int32) bool {
+	return c.pauser.IsPaused(topic, partition)
+}
+
+// GetLag returns how far the consumer group is behind on every partition of the subscribed topics.
+func (c *Consumer) GetLag(ctx context.Context) (map[TopicPartition]shared.Lag, error) {
+	return shared.GetLag(ctx, c.rawClient, c.groupName, c.GetTopics())
+}
+
+// PollLag calls callback with the result of GetLag every interval, until ctx is done or the consumer is closed.
+// It returns immediately, the polling happens in the background.
+func (c *Consumer) PollLag(ctx context.Context, interval time.Duration, callback shared.LagCallback) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.done:
+		}
+		cancel()
+	}()
+	go shared.PollLag(ctx, interval, c.GetLag, callback)
+}
+
+// GetOffsetStats returns the commit progress per partition of the current session.
+func (c *Consumer) GetOffsetStats() map[TopicPartition]shared.OffsetStats {
+	return c.offsets.Stats()
+}
+
 // GetStats returns marked and consumed message counts.
 func (c *Consumer) GetStats() (uint64, uint64) {
 	return c.markedMessages.Load(), c.consumedMessages.Load()
 }
 
+// updateState polls the state of the consumer group until the consumer is closed.
+// The cluster admin is recreated with backoff whenever it fails, StateError reports the failure in the meantime.
 func (c *Consumer) updateState() {
-	adminClient, err := sarama.NewClusterAdmin(c.brokers, sarama.NewConfig())
-	if err != nil {
-		zap.S().Fatal(err)
-	}
+	var adminClient sarama.ClusterAdmin
+	defer func() {
+		if adminClient != nil {
+			_ = adminClient.Close()
+		}
+	}()
+	var err error
 	var groups []*sarama.GroupDescription
 	var lastRunState bool
 	lastRunState = false
-	for {
+	attempt := 0
+	for !c.rawClient.Closed() {
+		if adminClient == nil {
+			adminClient, err = sarama.NewClusterAdmin(c.brokers, c.config.Sarama)
+			if err != nil {
+				adminClient = nil
+				c.stateFailed(err, attempt)
+				attempt++
+				continue
+			}
+		}
 		groups, err = adminClient.DescribeConsumerGroups([]string{c.groupName})
 		if err != nil {
-			zap.S().Warnf("failed to describe consumer groups: %s", err)
-			time.Sleep(shared.CycleTime * 10)
+			// Reconnect, the brokers the admin knows might be gone.
+			_ = adminClient.Close()
+			adminClient = nil
+			c.stateFailed(fmt.Errorf("failed to describe consumer group: %w", err), attempt)
+			attempt++
 			continue
 		}
 
 		if len(groups) != 1 {
-			zap.S().Warnf("expected 1 consumer group, got %d", len(groups))
+			c.log.Warnf("expected 1 consumer group, got %d", len(groups))
 			time.Sleep(shared.CycleTime * 10)
 			continue
 		}
 		currentGroup := groups[0]
+		if currentGroup.Err != sarama.ErrNoError {
+			c.stateFailed(fmt.Errorf("failed to describe consumer group: %w", currentGroup.Err), attempt)
+			attempt++
+			continue
+		}
+		attempt = 0
+		c.setStateError(nil)
 
 		switch currentGroup.State {
 		case "Empty":
-			c.groupState = ConsumerStateEmpty
+			c.groupState.Store(int32(ConsumerStateEmpty))
 		case "Stable":
-			c.groupState = ConsumerStateStable
+			c.groupState.Store(int32(ConsumerStateStable))
 			c.runConsumerGroup.Store(true)
 			lastRunState = true
 		case "PreparingRebalance":
-			c.groupState = ConsumerStatePreparingRebalance
+			c.groupState.Store(int32(ConsumerStatePreparingRebalance))
 			if lastRunState {
 				c.runConsumerGroup.Store(false)
 			}
 		case "CompletingRebalance":
-			c.groupState = ConsumerStateCompletingRebalance
+			c.groupState.Store(int32(ConsumerStateCompletingRebalance))
 		case "Dead":
-			c.groupState = ConsumerStateDead
+			c.groupState.Store(int32(ConsumerStateDead))
 		default:
-			c.groupState = ConsumerStateUnknown
-			zap.S().Warnf("unknown consumer group state: %s", currentGroup.State)
+			c.groupState.Store(int32(ConsumerStateUnknown))
+			c.log.Warnf("unknown consumer group state: %s", currentGroup.State)
 		}
 
 		time.Sleep(shared.CycleTime * 10)
 	}
+	c.log.Infof("stopped state updates")
+}
+
+// stateFailed records err as the reason the group state is unknown and waits before the next attempt.
+// Errors that will not go away by retrying are also reported on the Errors channel, once until the state recovers.
+func (c *Consumer) stateFailed(err error, attempt int) {
+	c.groupState.Store(int32(ConsumerStateUnknown))
+	classified := shared.NewClassifiedError(err)
+	if previous := c.setStateError(classified); previous == nil && classified.Class != shared.ErrorClassRetriable {
+		c.reportError(classified)
+	}
+	delay := c.config.Backoff.Delay(attempt)
+	c.log.Warnf("%s, retrying in %s", classified, delay)
+	time.Sleep(delay)
+}
+
+// setStateError replaces the error returned by StateError and returns the previous one.
+func (c *Consumer) setStateError(err error) error {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	previous := c.stateErr
+	c.stateErr = err
+	return previous
+}
+
+// StateError returns why GetState cannot currently be updated, wrapped in a *shared.ClassifiedError,
+// or nil once the group state is known again.
+func (c *Consumer) StateError() error {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return c.stateErr
 }
 
+// GetState returns the last known state of the consumer group.
 func (c *Consumer) GetState() ConsumerState {
-	return c.groupState
+	return ConsumerState(c.groupState.Load())
+}
+
+// Health returns the state of the consumer for health and readiness probes.
+// The consumer is unhealthy once it is closed or stopped after a terminal error,
+// and ready while it holds a consumer group session.
+func (c *Consumer) Health() shared.Health {
+	health := shared.Health{
+		Healthy:          true,
+		Ready:            c.ready.Load(),
+		ConnectedBrokers: shared.ConnectedBrokers(c.rawClient),
+		GroupState:       c.GetState().String(),
+		LastFetch:        c.activity.LastFetch(),
+		LastCommit:       c.activity.LastCommit(),
+	}
+	c.stateMutex.Lock()
+	terminalErr, stateErr := c.terminalErr, c.stateErr
+	c.stateMutex.Unlock()
+	select {
+	case <-c.done:
+		health.Healthy = false
+		health.Reason = "consumer closed"
+	default:
+		if terminalErr != nil {
+			health.Healthy = false
+			health.Reason = terminalErr.Error()
+		}
+	}
+	if health.Healthy && !health.Ready {
+		health.Reason = "no consumer group session"
+		if stateErr != nil {
+			health.Reason += ": " + stateErr.Error()
+		}
+	}
+	return health
 }
@@ -2,9 +2,13 @@ package raw
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/IBM/sarama"
 	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
 	"github.com/united-manufacturing-hub/umh-utils/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 	"os"
 	"testing"
 	"time"
@@ -85,7 +89,7 @@ breakOuter:
 
 	t.Log("Closing consumer")
 
-	err = testConsumer.Close()
+	err = testConsumer.Close(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -109,3 +113,164 @@ breakOuter:
 	time.Sleep(10 * time.Second)
 	t.Log("Goodbye")
 }
+
+func TestStateFailedReportsTerminalErrorsOnce(t *testing.T) {
+	config, err := shared.NewConfig(shared.Config{}, shared.WithBackoff(shared.Backoff{
+		Initial:    time.Millisecond,
+		Max:        time.Millisecond,
+		Multiplier: 1,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Consumer{config: config, errors: make(chan error, errorBufferSize), log: config.Logger.Sugar()}
+
+	c.stateFailed(sarama.ErrOutOfBrokers, 0)
+	if !errors.Is(c.StateError(), sarama.ErrOutOfBrokers) {
+		t.Fatalf("expected the state error to be recorded, got %v", c.StateError())
+	}
+	c.setStateError(nil)
+	c.stateFailed(sarama.ErrGroupAuthorizationFailed, 0)
+	c.stateFailed(sarama.ErrGroupAuthorizationFailed, 1)
+
+	if len(c.errors) != 1 {
+		t.Fatalf("expected only the first authorization failure to be reported, got %d errors", len(c.errors))
+	}
+	var classified *shared.ClassifiedError
+	if err = <-c.Errors(); !errors.As(err, &classified) || classified.Class != shared.ErrorClassAuth {
+		t.Fatalf("expected an auth error, got %v", err)
+	}
+}
+
+func TestMarkMessagesAfterCloseAreDropped(t *testing.T) {
+	c := &Consumer{messagesToMark: make(chan *shared.KafkaMessage, 1), done: make(chan struct{})}
+	close(c.done)
+
+	marked := make(chan struct{})
+	go func() {
+		c.MarkMessages([]*shared.KafkaMessage{{Offset: 1}, {Offset: 2}, {Offset: 3}})
+		close(marked)
+	}()
+	select {
+	case <-marked:
+	case <-time.After(time.Second):
+		t.Fatal("expected marking to return once the consumer is closed")
+	}
+}
+
+func TestRecheckDoesNotRestartAfterTerminalError(t *testing.T) {
+	broker := newMockCluster(t)
+	defer broker.Close()
+
+	core, logs := observer.New(zap.WarnLevel)
+	consumer, err := NewConsumer([]string{broker.Addr()}, []string{"umh.v1.*"}, "test-group", "test-1", shared.WithLogger(zap.New(core)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close(context.Background())
+	// The consume loop stopped with a terminal error, then a new topic matching the subscription appears.
+	consumer.externalCtx = context.Background()
+	consumer.internalCtx, consumer.consumerContextCancel = context.WithCancel(consumer.externalCtx)
+	consumer.terminalErr = shared.NewClassifiedError(sarama.ErrTopicAuthorizationFailed)
+	go consumer.recheck()
+
+	deadline := time.After(5 * time.Second)
+	for logs.FilterMessageSnippet("terminal error").Len() == 0 {
+		select {
+		case <-deadline:
+			t.Fatal("recheck did not notice the topic change")
+		case <-time.After(shared.CycleTime):
+		}
+	}
+	if consumer.IsRunning() || consumer.consuming.Load() {
+		t.Fatal("expected the consumer to stay stopped after a terminal error")
+	}
+	if health := consumer.Health(); health.Healthy {
+		t.Fatal("expected the consumer to stay unhealthy after a terminal error")
+	}
+}
+
+const testTopic = "umh.v1.raw.test"
+
+// newMockCluster returns a broker holding a stable consumer group "test-group" with testTopic assigned to it.
+// Both partitions of testTopic contain the messages at offsets 0 and 1.
+func newMockCluster(t *testing.T) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 0)
+	fetchResponse := sarama.NewMockFetchResponse(t, 2)
+	offsetResponse := sarama.NewMockOffsetResponse(t)
+	offsetFetchResponse := sarama.NewMockOffsetFetchResponse(t).SetError(sarama.ErrNoError)
+	for partition := int32(0); partition < 2; partition++ {
+		fetchResponse.
+			SetMessage(testTopic, partition, 0, sarama.StringEncoder("foo")).
+			SetMessage(testTopic, partition, 1, sarama.StringEncoder("bar")).
+			SetHighWaterMark(testTopic, partition, 2)
+		offsetResponse.
+			SetOffset(testTopic, partition, sarama.OffsetOldest, 0).
+			SetOffset(testTopic, partition, sarama.OffsetNewest, 2)
+		offsetFetchResponse.SetOffset("test-group", testTopic, partition, 0, "", sarama.ErrNoError)
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()).
+			SetLeader(testTopic, 1, broker.BrokerID()),
+		"OffsetRequest": offsetResponse,
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "test-group", broker),
+		"HeartbeatRequest": sarama.NewMockHeartbeatResponse(t),
+		"JoinGroupRequest": sarama.NewMockJoinGroupResponse(t).
+			SetGroupProtocol(sarama.RangeBalanceStrategyName),
+		"SyncGroupRequest": sarama.NewMockSyncGroupResponse(t).SetMemberAssignment(
+			&sarama.ConsumerGroupMemberAssignment{
+				Version: 0,
+				Topics: map[string][]int32{
+					testTopic: {0, 1},
+				},
+			}),
+		"OffsetFetchRequest":  offsetFetchResponse,
+		"FetchRequest":        fetchResponse,
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+		"LeaveGroupRequest":   sarama.NewMockLeaveGroupResponse(t),
+		"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(t).
+			AddGroupDescription("test-group", &sarama.GroupDescription{GroupId: "test-group", State: "Stable"}),
+	})
+	return broker
+}
+
+func TestSeekToOffsetWithMultiplePartitions(t *testing.T) {
+	broker := newMockCluster(t)
+	defer broker.Close()
+
+	consumer, err := NewConsumer([]string{broker.Addr()}, []string{"umh.v1.*"}, "test-group", "test-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cncl := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cncl()
+	if err = consumer.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close(context.Background())
+
+	receive := func() *shared.KafkaMessage {
+		msg, err := consumer.GetMessage(ctx)
+		if err != nil {
+			t.Fatal("no message received")
+		}
+		return msg
+	}
+	for i := 0; i < 4; i++ {
+		consumer.MarkMessage(receive())
+	}
+
+	// Only one claim receives the restart signal, the session must still end for both partitions.
+	consumer.SeekToOffset(testTopic, 1, 1)
+	for {
+		msg := receive()
+		if msg.Partition == 1 && msg.Offset == 1 {
+			break
+		}
+		consumer.MarkMessage(msg)
+	}
+}
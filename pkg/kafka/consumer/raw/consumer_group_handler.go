@@ -4,73 +4,161 @@ import (
 	"github.com/IBM/sarama"
 	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
 	"go.uber.org/zap"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 type GroupHandler struct {
 	running          *atomic.Bool
+	ready            *atomic.Bool
 	markedMessages   *atomic.Uint64
 	consumedMessages *atomic.Uint64
 	incomingMessages chan *shared.KafkaMessage
 	messagesToMark   chan *shared.KafkaMessage
+	commitInterval   time.Duration
+	config           *shared.Config
+	offsets          *shared.OffsetTracker
+	groupName        string
+	client           sarama.Client
+	seeker           *shared.Seeker
+	pauser           *shared.Pauser
+	group            sarama.ConsumerGroup
+	activity         *shared.Activity
+	// log carries the group ID, sessionLog adds member ID and generation of the current session.
+	log        *zap.SugaredLogger
+	sessionLog *zap.SugaredLogger
 }
 
-func (c *GroupHandler) Setup(_ sarama.ConsumerGroupSession) error {
-	zap.S().Debugf("Hello from setup")
+func (c *GroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	c.sessionLog = c.log.With("member_id", session.MemberID(), "generation", session.GenerationID())
+	// Offsets of the previous session are redelivered, marks for them can no longer be committed.
+	c.offsets.Reset()
+	c.seeker.Apply(session, c.client, c.sessionLog)
+	c.pauser.SetClaims(session.Claims())
+	c.config.Metrics.Rebalance(c.groupName)
+	c.config.NotifyAssigned(session)
+	c.ready.Store(true)
+	c.sessionLog.Debugf("Hello from setup")
 	return nil
 }
 
-func commit(session sarama.ConsumerGroupSession) chan bool {
+func commit(session sarama.ConsumerGroupSession, metrics shared.MetricsRecorder, log *zap.SugaredLogger) {
 	now := time.Now()
-	zap.S().Debugf("Committing messages")
+	log.Debugf("Committing messages")
 	session.Commit()
-	zap.S().Debugf("Commit took %s", time.Since(now))
-	return nil
+	took := time.Since(now)
+	metrics.CommitDuration(took)
+	log.Debugf("Commit took %s", took)
 }
 
 func (c *GroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	c.ready.Store(false)
+	c.pauser.SetClaims(nil)
+	if c.config.RebalanceListener != nil && !c.config.NotifyRevoked(c.client, c.groupName, session) {
+		// Commit what the listener marked while the partitions were revoked.
+		c.drainMarks()
+		markCommittable(session, c.offsets, c.config.Metrics, c.activity, c.sessionLog)
+	}
 	timeout := time.NewTimer(30 * time.Second)
+	defer timeout.Stop()
+	committed := make(chan struct{})
+	go func() {
+		commit(session, c.config.Metrics, c.sessionLog)
+		close(committed)
+	}()
 
+	// The claims stopped their goroutines before returning, so running is left to the group state.
 	select {
 	case <-timeout.C:
-		zap.S().Debugf("Timeout reached, closing consumer")
-		c.running.Store(false)
+		c.sessionLog.Debugf("Timeout reached, closing consumer")
 		return nil
-	case <-commit(session):
-		zap.S().Debugf("Cleanup commit finished")
+	case <-committed:
+		c.sessionLog.Debugf("Cleanup commit finished")
 	}
-
-	c.running.Store(false)
-	// Wait for one cycle to finish
-	time.Sleep(shared.CycleTime)
-	zap.S().Debugf("Goodbye from cleanup")
+	c.sessionLog.Debugf("Goodbye from cleanup")
 	return nil
 }
 
+// drainMarks acknowledges all messages waiting to be marked.
+func (c *GroupHandler) drainMarks() {
+	for {
+		select {
+		case message := <-c.messagesToMark:
+			if message == nil {
+				continue
+			}
+			c.offsets.Ack(message)
+			c.markedMessages.Add(1)
+			c.config.Metrics.MessageMarked(message.Topic, message.Partition)
+		default:
+			return
+		}
+	}
+}
+
 func (c *GroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	// sarama does not keep pauses of partitions it reassigns, so they are applied once the claim exists.
+	c.pauser.Sync(c.group)
 	// This must be smaller then Config.Consumer.Group.Rebalance.Timeout (default 60s)
-	go consumer(&session, &claim, c.incomingMessages, c.running, c.consumedMessages)
-	go marker(&session, c.messagesToMark, c.running, c.markedMessages)
-	// Wait for c.running to be false
-	var err error
+	log := c.sessionLog.With("topic", claim.Topic(), "partition", claim.Partition())
+	// The goroutines of the claim stop when it returns, so nothing is marked on a session that has ended.
+	stop := make(chan struct{})
+	var workers sync.WaitGroup
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		consumer(&session, &claim, c.incomingMessages, c.running, stop, c.consumedMessages, c.config, c.offsets, c.activity, log)
+	}()
+	go func() {
+		defer workers.Done()
+		marker(&session, c.messagesToMark, c.running, stop, c.markedMessages, c.commitInterval, c.offsets, c.config.Metrics, c.activity, c.sessionLog)
+	}()
+	defer func() {
+		close(stop)
+		workers.Wait()
+	}()
+	// Wait for c.running to be false, the end of the session, or end the session to apply pending seeks
 	for c.running.Load() {
-		time.Sleep(shared.CycleTime * 10)
+		select {
+		case <-c.seeker.Restart():
+			log.Infof("Ending session to apply seeks")
+			return nil
+		case <-session.Context().Done():
+			// Another claim ended the session, or the group rebalances.
+			log.Debugf("Session context closed")
+			return nil
+		case <-time.After(shared.CycleTime * 10):
+		}
 	}
-	zap.S().Debugf("Goodbye from consume claim (%d-%s)", session.GenerationID(), session.MemberID())
-	return err
+	log.Debugf("Goodbye from consume claim (%d-%s)", session.GenerationID(), session.MemberID())
+	return nil
 }
 
-type TopicPartition struct {
-	Topic     string
-	Partition int32
+// TopicPartition identifies a partition of a topic.
+type TopicPartition = shared.TopicPartition
+
+// markCommittable marks the highest contiguous processed offset of every partition and commits them.
+func markCommittable(session sarama.ConsumerGroupSession, offsets *shared.OffsetTracker, metrics shared.MetricsRecorder, activity *shared.Activity, log *zap.SugaredLogger) {
+	committable := offsets.Committable()
+	for k, v := range committable {
+		session.MarkOffset(k.Topic, k.Partition, v, "")
+		metrics.OffsetMarked(k.Topic, k.Partition, v)
+	}
+	commit(session, metrics, log)
+	activity.Committed()
+	for k, v := range committable {
+		metrics.OffsetCommitted(k.Topic, k.Partition, v)
+	}
 }
 
-func marker(session *sarama.ConsumerGroupSession, messagesToMark chan *shared.KafkaMessage, running *atomic.Bool, markedMessages *atomic.Uint64) {
+// marker acknowledges marked messages and commits them periodically, until running is false or stop is closed.
+func marker(session *sarama.ConsumerGroupSession, messagesToMark chan *shared.KafkaMessage, running *atomic.Bool, stop <-chan struct{}, markedMessages *atomic.Uint64, commitInterval time.Duration, offsets *shared.OffsetTracker, metrics shared.MetricsRecorder, activity *shared.Activity, log *zap.SugaredLogger) {
 	lastCommit := time.Now()
-	offsets := make(map[TopicPartition]int64)
-	for running.Load() {
+	for stopped := false; running.Load() && !stopped; {
 		select {
+		case <-stop:
+			stopped = true
 		case message := <-messagesToMark:
 			if session == nil || (*session) == nil {
 				running.Store(false)
@@ -80,45 +168,34 @@ func marker(session *sarama.ConsumerGroupSession, messagesToMark chan *shared.Ka
 				continue
 			}
 
-			key := TopicPartition{
-				Topic:     message.Topic,
-				Partition: message.Partition,
-			}
-			if v, ok := offsets[key]; ok {
-				if v < message.Offset {
-					offsets[key] = message.Offset
-				}
-			} else {
-				offsets[key] = message.Offset
-			}
+			offsets.Ack(message)
 			markedMessages.Add(1)
+			metrics.MessageMarked(message.Topic, message.Partition)
 
-			if markedMessages.Load()%10000 == 0 || time.Since(lastCommit) > 10*time.Second {
+			if markedMessages.Load()%10000 == 0 || time.Since(lastCommit) > commitInterval {
 				lastCommit = time.Now()
-				for k, v := range offsets {
-					(*session).MarkOffset(k.Topic, k.Partition, v, "")
-				}
-				commit(*session)
+				markCommittable(*session, offsets, metrics, activity, log)
 			}
 		case <-time.After(shared.CycleTime):
 			continue
 		}
 	}
 
-	zap.S().Debugf("Committing messages")
-	for k, v := range offsets {
-		(*session).MarkOffset(k.Topic, k.Partition, v, "")
-	}
-	commit(*session)
-	zap.S().Debugf("Goodbye from marker (%d-%s)", (*session).GenerationID(), (*session).MemberID())
+	log.Debugf("Committing messages")
+	markCommittable(*session, offsets, metrics, activity, log)
+	log.Debugf("Goodbye from marker (%d-%s)", (*session).GenerationID(), (*session).MemberID())
 }
 
-func consumer(session *sarama.ConsumerGroupSession, claim *sarama.ConsumerGroupClaim, incomingMessages chan *shared.KafkaMessage, running *atomic.Bool, consumedMessages *atomic.Uint64) {
+// consumer passes the messages of claim to incomingMessages, until running is false or stop is closed.
+func consumer(session *sarama.ConsumerGroupSession, claim *sarama.ConsumerGroupClaim, incomingMessages chan *shared.KafkaMessage, running *atomic.Bool, stop <-chan struct{}, consumedMessages *atomic.Uint64, config *shared.Config, offsets *shared.OffsetTracker, activity *shared.Activity, log *zap.SugaredLogger) {
 	timer := time.NewTimer(shared.CycleTime)
 	timerTenSeconds := time.NewTimer(10 * time.Second)
 	messagesHandledCurrTenSeconds := 0.0
 	for running.Load() {
 		select {
+		case <-stop:
+			log.Debugf("Goodbye from consumer (%d-%s)", (*session).GenerationID(), (*session).MemberID())
+			return
 		case message := <-(*claim).Messages():
 			if session == nil {
 				running.Store(false)
@@ -127,17 +204,27 @@ func consumer(session *sarama.ConsumerGroupSession, claim *sarama.ConsumerGroupC
 				time.Sleep(shared.CycleTime)
 				continue
 			}
-			// Add to incoming message channel, else block
-			incomingMessages <- shared.FromConsumerMessage(message)
+			// Add to incoming message channel, else block until the claim ends
+			msg := config.FromConsumerMessage(message)
+			offsets.Track(msg)
+			select {
+			case incomingMessages <- msg:
+			case <-stop:
+				// The message is delivered again in the next session, which resets the offset tracker.
+				return
+			}
 			consumedMessages.Add(1)
+			activity.Fetched()
+			config.Metrics.MessageConsumed(message.Topic, message.Partition)
+			config.Metrics.ConsumerLag(message.Topic, message.Partition, (*claim).HighWaterMarkOffset()-message.Offset-1)
 			messagesHandledCurrTenSeconds++
 		case <-timer.C:
 			timer.Reset(shared.CycleTime)
 			continue
 		case <-timerTenSeconds.C:
-			zap.S().Debugf("Consumer for session %s:%d is running", (*session).MemberID(), (*session).GenerationID())
+			log.Debugf("Consumer for session %s:%d is running", (*session).MemberID(), (*session).GenerationID())
 			continue
 		}
 	}
-	zap.S().Debugf("Goodbye from consumer (%d-%s)", (*session).GenerationID(), (*session).MemberID())
+	log.Debugf("Goodbye from consumer (%d-%s)", (*session).GenerationID(), (*session).MemberID())
 }
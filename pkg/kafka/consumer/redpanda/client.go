@@ -48,6 +48,24 @@ type Consumer struct {
 	// config holds the Sarama consumer configuration.
 	config *sarama.Config
 
+	// options holds the wrapper settings the consumer was created with.
+	options *shared.Config
+
+	// offsets tracks consumed and marked offsets, so only contiguously processed offsets are committed.
+	offsets *shared.OffsetTracker
+
+	// seeker holds seeks that are applied with the next assignment.
+	seeker *shared.Seeker
+
+	// pauser holds paused partitions, which stay paused across rebalances and reconnects.
+	pauser *shared.Pauser
+
+	// activity records when messages were last consumed and committed.
+	activity shared.Activity
+
+	// log carries the group ID as a field.
+	log *zap.SugaredLogger
+
 	// brokers lists the Kafka brokers.
 	brokers []string
 
@@ -56,8 +74,28 @@ type Consumer struct {
 
 	// consumerGroup is the Sarama consumer group.
 	consumerGroup *sarama.ConsumerGroup
+
+	// clientMutex protects client and consumerGroup, which are replaced by start and closed by refreshTopics.
+	clientMutex sync.Mutex
+
+	// ctx is cancelled by Close to stop the consume and topic refresh loops.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// closing is closed by Close to make the handlers flush pending marks and commit.
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	// done is closed once the consumer has left the group and closed its client.
+	done chan struct{}
+
+	// closeErr holds the error of the final consumer group and client shutdown.
+	closeErr error
 }
 
+// ErrConsumerClosed is returned when using a consumer that has been closed.
+var ErrConsumerClosed = errors.New("consumer closed")
+
 // genIID generates an instance ID by appending a timestamp to the provided instanceId and hashing it.
 func genIID(instanceId string) string {
 	// Append random suffix to avoid conflicts
@@ -71,134 +109,172 @@ func genIID(instanceId string) string {
 }
 
 // NewConsumer initializes and returns a new Consumer instance.
-func NewConsumer(kafkaBrokers, subscribeRegexes []string, groupId, instanceId string, initialOffset int64) (*Consumer, error) {
-	zap.S().Infof("Connecting to brokers: %v", kafkaBrokers)
-	zap.S().Infof("Creating new consumer with Group ID: %s, Instance ID: %s", groupId, instanceId)
-	zap.S().Infof("Subscribing to topics: %v", subscribeRegexes)
-
-	sarama.Logger = zap.NewStdLog(zap.L())
+// The opts are applied on top of the defaults derived from the other parameters.
+func NewConsumer(kafkaBrokers, subscribeRegexes []string, groupId, instanceId string, initialOffset int64, opts ...shared.Option) (*Consumer, error) {
+	defaults := sarama.NewConfig()
+	defaults.Consumer.Offsets.Initial = initialOffset
+	defaults.Consumer.Offsets.AutoCommit.Enable = true
+	defaults.Consumer.Offsets.AutoCommit.Interval = 1 * time.Second
+	defaults.Consumer.Group.InstanceId = genIID(instanceId)
+	defaults.Version = sarama.V2_3_0_0
+	defaults.Metadata.RefreshFrequency = 1 * time.Minute
+
+	config, err := shared.NewConfig(shared.Config{Sarama: defaults}, opts...)
+	if err != nil {
+		return nil, err
+	}
 
-	config := sarama.NewConfig()
-	config.Consumer.Offsets.Initial = initialOffset
-	config.Consumer.Offsets.AutoCommit.Enable = true
-	config.Consumer.Offsets.AutoCommit.Interval = 1 * time.Second
-	config.Consumer.Group.InstanceId = genIID(instanceId)
-	config.Version = sarama.V2_3_0_0
-	config.Metadata.RefreshFrequency = 1 * time.Minute
+	log := config.Logger.Sugar().With("group_id", groupId)
+	log.Infof("Connecting to brokers: %v", kafkaBrokers)
+	log.Infof("Creating new consumer with Group ID: %s, Instance ID: %s", groupId, instanceId)
+	log.Infof("Subscribing to topics: %v", subscribeRegexes)
 
 	c := Consumer{}
+	c.log = log
 	c.subscribeRegexes = make([]*regexp.Regexp, len(subscribeRegexes))
 	for i, regex := range subscribeRegexes {
 		re, err := regexp.Compile(regex)
 		if err != nil {
-			zap.S().Errorf("Failed to compile regex: %v", err)
+			log.Errorf("Failed to compile regex: %v", err)
 			return nil, err
 		}
 		c.subscribeRegexes[i] = re
 	}
 	c.groupId = groupId
-	c.config = config
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.closing = make(chan struct{})
+	c.done = make(chan struct{})
+	c.config = config.Sarama
+	c.options = config
+	c.offsets = shared.NewOffsetTracker()
+	c.seeker = shared.NewSeeker()
+	c.pauser = shared.NewPauser()
 	c.brokers = kafkaBrokers
 
-	zap.S().Debugf("Setting up channels")
-	c.incomingMessages = make(chan *shared.KafkaMessage, 100_000)
-	c.messagesToMarkChan = make(chan *shared.KafkaMessage, 100_000)
+	log.Debugf("Setting up channels")
+	c.incomingMessages = make(chan *shared.KafkaMessage, config.ChannelBufferSize)
+	c.messagesToMarkChan = make(chan *shared.KafkaMessage, config.ChannelBufferSize)
 
-	zap.S().Debugf("Setting up initial client")
-	newClient, err := sarama.NewClient(kafkaBrokers, config)
+	log.Debugf("Setting up initial client")
+	newClient, err := sarama.NewClient(kafkaBrokers, c.config)
 	if err != nil {
-		zap.S().Errorf("Failed to create new client: %v", err)
+		log.Errorf("Failed to create new client: %v", err)
 		return nil, err
 	}
 
 	for {
 		err = newClient.RefreshMetadata()
 		if err != nil {
-			zap.S().Errorf("Failed to refresh metadata: %v", err)
+			log.Errorf("Failed to refresh metadata: %v", err)
 			return nil, err
 		}
 
 		var topics []string
 		topics, err = newClient.Topics()
 		if err != nil {
-			zap.S().Errorf("Failed to retrieve topics: %v", err)
+			log.Errorf("Failed to retrieve topics: %v", err)
 			return nil, err
 		}
-		zap.S().Debugf("Filtering topics")
-		topics = filter(topics, c.subscribeRegexes)
+		log.Debugf("Filtering topics")
+		topics = filter(topics, c.subscribeRegexes, log)
 		if len(topics) > 0 {
 			c.topicsMutex.Lock()
 			c.topics = topics
 			c.topicsMutex.Unlock()
 			break
 		}
-		zap.S().Infof("No topics found. Waiting for 1 second")
+		log.Infof("No topics found. Waiting for 1 second")
 		time.Sleep(1 * time.Second)
 	}
 	err = newClient.Close()
 	if err != nil {
-		zap.S().Warnf("Failed to close initial client: %s", err)
+		log.Warnf("Failed to close initial client: %s", err)
 	}
 
-	go c.start()
-	go c.refreshTopics()
+	var loops sync.WaitGroup
+	loops.Add(2)
+	go func() {
+		defer loops.Done()
+		c.start()
+	}()
+	go func() {
+		defer loops.Done()
+		c.refreshTopics()
+	}()
+	unregisterMetrics := []func(){
+		config.Metrics.RegisterChannel(groupId, "incoming", func() (int, int) {
+			return len(c.incomingMessages), cap(c.incomingMessages)
+		}),
+		config.Metrics.RegisterChannel(groupId, "to_mark", func() (int, int) {
+			return len(c.messagesToMarkChan), cap(c.messagesToMarkChan)
+		}),
+		config.Metrics.RegisterSaramaRegistry(c.config.ClientID, c.config.MetricRegistry),
+	}
+	go func() {
+		loops.Wait()
+		for _, unregister := range unregisterMetrics {
+			unregister()
+		}
+		close(c.done)
+	}()
 
-	zap.S().Debugf("Consumer initialized with Group ID: %s, Instance ID: %s, Brokers: %v", groupId, instanceId, kafkaBrokers)
+	log.Debugf("Consumer initialized with Group ID: %s, Instance ID: %s, Brokers: %v", groupId, instanceId, kafkaBrokers)
 	return &c, nil
 }
 
 // start begins the consumption process for Kafka messages.
 // If required it also re-initializes the consumer.
+// It returns once the consumer is closed.
 func (c *Consumer) start() {
-	zap.S().Debugf("Starting consumer with Group ID: %s", c.groupId)
+	c.log.Debugf("Starting consumer with Group ID: %s", c.groupId)
+	defer func() {
+		c.closeErr = c.closeClient()
+		c.log.Debugf("Consumer stopped for Group ID: %s", c.groupId)
+	}()
 	var err error
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	for {
+	for c.ctx.Err() == nil && !c.isClosing() {
 		var topics []string
 		c.topicsMutex.RLock()
 		topics = make([]string, len(c.topics))
 		copy(topics, c.topics)
 		c.topicsMutex.RUnlock()
 		if len(topics) == 0 {
-			zap.S().Infof("No topics found. Waiting for 1 second")
-			time.Sleep(1 * time.Second)
+			c.log.Infof("No topics found. Waiting for 1 second")
+			sleep(c.ctx, 1*time.Second)
 			continue
 		}
-		zap.S().Debugf("Got topics: %v", topics)
+		c.log.Debugf("Got topics: %v", topics)
 
-		if c.client != nil {
-			if !(*c.client).Closed() {
-				zap.S().Infof("Closing old client")
-				err = (*c.client).Close()
-				if err != nil {
-					zap.S().Warnf("Failed to close client: %s", err)
-				}
-			}
+		err = c.closeClient()
+		if err != nil {
+			c.log.Warnf("Failed to close client: %s", err)
 		}
-		zap.S().Debugf("Creating new client")
+		c.log.Debugf("Creating new client")
 		var client sarama.Client
 		c.config.Consumer.Group.InstanceId = genIID(c.config.Consumer.Group.InstanceId)
-		zap.S().Debugf("Using instanceId %s", c.config.Consumer.Group.InstanceId)
+		c.log.Debugf("Using instanceId %s", c.config.Consumer.Group.InstanceId)
 		client, err = sarama.NewClient(c.brokers, c.config)
-		c.client = &client
 		if err != nil {
-			zap.S().Errorf("Failed to create new client: %v", err)
-			time.Sleep(1 * time.Second)
+			c.log.Errorf("Failed to create new client: %v", err)
+			sleep(c.ctx, 1*time.Second)
 			continue
 		}
 
-		zap.S().Debugf("Creating new consumer")
+		c.log.Debugf("Creating new consumer")
 		consumer, err := sarama.NewConsumerGroupFromClient(c.groupId, client)
 		if err != nil {
-			zap.S().Errorf("Failed to create new consumer: %v", err)
-			time.Sleep(1 * time.Second)
+			c.log.Errorf("Failed to create new consumer: %v", err)
+			_ = client.Close()
+			sleep(c.ctx, 1*time.Second)
 			continue
 		}
+		c.clientMutex.Lock()
+		c.client = &client
 		c.consumerGroup = &consumer
+		c.clientMutex.Unlock()
 
 		// Consume loop
-		zap.S().Infof("Starting to consume messages")
+		c.log.Infof("Starting to consume messages")
 		for {
 			cgh := ConsumerGroupHandler{
 				incomingMessages:   c.incomingMessages,
@@ -206,88 +282,272 @@ func (c *Consumer) start() {
 				ready:              &c.isReady,
 				read:               &c.read,
 				marked:             &c.marked,
+				closing:            c.closing,
+				options:            c.options,
+				offsets:            c.offsets,
+				groupId:            c.groupId,
+				client:             client,
+				seeker:             c.seeker,
+				pauser:             c.pauser,
+				group:              consumer,
+				activity:           &c.activity,
+				log:                c.log,
 			}
-			zap.S().Debugf("CHG topics: %v", topics)
-			err = consumer.Consume(ctx, topics, &cgh)
+			c.log.Debugf("CHG topics: %v", topics)
+			err = consumer.Consume(c.ctx, topics, &cgh)
 			if errors.Is(err, sarama.ErrClosedClient) {
-				zap.S().Infof("Consumer closed")
-				time.Sleep(5 * time.Second)
+				c.log.Infof("Consumer closed")
+				sleep(c.ctx, 5*time.Second)
 				break
 			} else if errors.Is(err, sarama.ErrClosedConsumerGroup) {
-				zap.S().Infof("Consumer group closed")
-				time.Sleep(5 * time.Second)
+				c.log.Infof("Consumer group closed")
+				sleep(c.ctx, 5*time.Second)
 				break
 			} else if err != nil {
-				zap.S().Errorf("Consumer error: %v", err)
-				time.Sleep(1 * time.Second)
+				c.log.Errorf("Consumer error: %v", err)
+				sleep(c.ctx, 1*time.Second)
 			}
-			if ctx.Err() != nil {
-				zap.S().Infof("Context closed")
+			if c.ctx.Err() != nil || c.isClosing() {
+				c.log.Infof("Context closed")
 				break
 			}
 			time.Sleep(10 * time.Millisecond)
 		}
-		zap.S().Debugf("Consumer start loop ended for Group ID: %s", c.groupId)
+		c.log.Debugf("Consumer start loop ended for Group ID: %s", c.groupId)
 	}
 }
 
+// closeClient leaves the consumer group and closes the current client, if any.
+func (c *Consumer) closeClient() error {
+	c.clientMutex.Lock()
+	defer c.clientMutex.Unlock()
+	var err error
+	if c.consumerGroup != nil {
+		err = (*c.consumerGroup).Close()
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			err = nil
+		}
+		c.consumerGroup = nil
+	}
+	if c.client != nil {
+		if !(*c.client).Closed() {
+			c.log.Infof("Closing old client")
+			err = errors.Join(err, (*c.client).Close())
+		}
+		c.client = nil
+	}
+	return err
+}
+
 // refreshTopics periodically updates the list of topics the consumer subscribes to.
+// It returns once the consumer is closed.
 func (c *Consumer) refreshTopics() {
 
 	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
 	for {
-		zap.S().Debugf("Starting topic refresh for consumer with Group ID: %s", c.groupId)
-		<-ticker.C
-		if c.client == nil {
-			zap.S().Debugf("Client not ready")
+		c.log.Debugf("Starting topic refresh for consumer with Group ID: %s", c.groupId)
+		select {
+		case <-c.ctx.Done():
+			c.log.Debugf("Topic refresh stopped for Group ID: %s", c.groupId)
+			return
+		case <-c.closing:
+			c.log.Debugf("Topic refresh stopped for Group ID: %s", c.groupId)
+			return
+		case <-ticker.C:
+		}
+		c.clientMutex.Lock()
+		client := c.client
+		c.clientMutex.Unlock()
+		if client == nil {
+			c.log.Debugf("Client not ready")
 			continue
 		}
-		zap.S().Debugf("Refreshing metadata")
+		c.log.Debugf("Refreshing metadata")
 
-		err := (*c.client).RefreshMetadata()
+		err := (*client).RefreshMetadata()
 		if err != nil {
-			zap.S().Errorf("Error refreshing metadata: %v", err)
+			c.log.Errorf("Error refreshing metadata: %v", err)
 			continue
 		}
 
-		topics, err := (*c.client).Topics()
+		topics, err := (*client).Topics()
 		if err != nil {
-			zap.S().Errorf("Error getting topics: %v", err)
+			c.log.Errorf("Error getting topics: %v", err)
 			continue
 		}
 
-		topics = filter(topics, c.subscribeRegexes)
+		topics = filter(topics, c.subscribeRegexes, c.log)
 		c.topicsMutex.RLock()
 		compare := slices.Compare(c.topics, topics)
 		c.topicsMutex.RUnlock()
 		if compare == 0 {
-			zap.S().Infof("No change in topics")
+			c.log.Infof("No change in topics")
 			continue
 		}
 		c.topicsMutex.Lock()
-		zap.S().Infof("Detected topic change. Old topics: %v, New topics: %v", c.topics, topics)
+		c.log.Infof("Detected topic change. Old topics: %v, New topics: %v", c.topics, topics)
 		c.topics = topics
 		c.topicsMutex.Unlock()
 
+		c.clientMutex.Lock()
 		if c.consumerGroup != nil {
 			err = (*c.consumerGroup).Close()
 			if err != nil {
-				zap.S().Warnf("Failed to close consumer group: %s", err)
+				c.log.Warnf("Failed to close consumer group: %s", err)
 			}
 		}
 		if c.client != nil {
 			err = (*c.client).Close()
 			if err != nil {
-				zap.S().Warnf("Failed to close client: %s", err)
+				c.log.Warnf("Failed to close client: %s", err)
 			}
 		}
-		zap.S().Debugf("Refresh loop ended")
+		c.clientMutex.Unlock()
+		c.log.Debugf("Refresh loop ended")
 		// Reset the ticker to avoid a burst of refreshes
 		ticker.Reset(5 * time.Second)
-		zap.S().Debugf("Topic refresh loop ended for Group ID: %s", c.groupId)
+		c.log.Debugf("Topic refresh loop ended for Group ID: %s", c.groupId)
+	}
+}
+
+// Start exists to satisfy the kafka.Consumer interface, the consumer already starts consuming in NewConsumer.
+// It returns ErrConsumerClosed if the consumer has been closed.
+func (c *Consumer) Start(_ context.Context) error {
+	if c.isClosing() {
+		return ErrConsumerClosed
+	}
+	return nil
+}
+
+// Close stops the topic refresh, flushes pending marks, commits, leaves the consumer group and closes the client.
+// It returns ctx.Err() if ctx is done before the shutdown finished, in which case the shutdown continues in the background.
+func (c *Consumer) Close(ctx context.Context) error {
+	c.closeOnce.Do(func() {
+		c.log.Infof("Closing consumer with Group ID: %s", c.groupId)
+		c.isReady.Store(false)
+		close(c.closing)
+		// Give the handlers a chance to flush pending marks before the session is torn down.
+		go func() {
+			select {
+			case <-time.After(shared.CycleTime * 10):
+			case <-c.done:
+			}
+			c.cancel()
+		}()
+	})
+
+	select {
+	case <-c.done:
+		return c.closeErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isClosing returns whether Close has been called.
+func (c *Consumer) isClosing() bool {
+	select {
+	case <-c.closing:
+		return true
+	default:
+		return false
 	}
 }
 
+// Done returns a channel that is closed once the consumer has been closed completely.
+func (c *Consumer) Done() <-chan struct{} {
+	return c.done
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// SeekToOffset makes the consumer continue at offset on the partition, once it is assigned next.
+// The current session ends, so assigned partitions are repositioned right away.
+func (c *Consumer) SeekToOffset(topic string, partition int32, offset int64) {
+	c.seeker.SeekToOffset(topic, partition, offset)
+}
+
+// SeekToBeginning makes the consumer continue at the oldest retained message of the partition, see SeekToOffset.
+func (c *Consumer) SeekToBeginning(topic string, partition int32) {
+	c.seeker.SeekToBeginning(topic, partition)
+}
+
+// SeekToEnd makes the consumer skip all messages currently in the partition, see SeekToOffset.
+func (c *Consumer) SeekToEnd(topic string, partition int32) {
+	c.seeker.SeekToEnd(topic, partition)
+}
+
+// SeekToTimestamp makes the consumer continue at the first message of the partition with a timestamp at or after t,
+// see SeekToOffset.
+func (c *Consumer) SeekToTimestamp(topic string, partition int32, t time.Time) {
+	c.seeker.SeekToTimestamp(topic, partition, t)
+}
+
+// SeekAllToTimestamp applies SeekToTimestamp to every partition of the next assignment,
+// for example to replay the last hour with SeekAllToTimestamp(time.Now().Add(-time.Hour)).
+func (c *Consumer) SeekAllToTimestamp(t time.Time) {
+	c.seeker.SeekAllToTimestamp(t)
+}
+
+// Pause stops fetching from the given partitions without leaving the consumer group,
+// an empty partition list pauses the whole topic. Messages that were already fetched are still delivered.
+// Pauses stay in place across rebalances until they are lifted by Resume or ResumeAll.
+func (c *Consumer) Pause(partitions map[string][]int32) {
+	c.pauser.Pause(partitions)
+	c.syncPauses()
+}
+
+// Resume lifts pauses of the given partitions, an empty partition list lifts all pauses of the topic.
+func (c *Consumer) Resume(partitions map[string][]int32) {
+	c.pauser.Resume(partitions)
+	c.syncPauses()
+}
+
+// PauseAll stops fetching from all partitions, including partitions assigned later, see Pause.
+func (c *Consumer) PauseAll() {
+	c.pauser.PauseAll()
+	c.syncPauses()
+}
+
+// ResumeAll lifts all pauses.
+func (c *Consumer) ResumeAll() {
+	c.pauser.ResumeAll()
+	c.syncPauses()
+}
+
+// Paused returns the paused partitions of the current session.
+func (c *Consumer) Paused() map[string][]int32 {
+	return c.pauser.Paused()
+}
+
+// IsPaused returns whether the partition is paused, regardless of whether it is currently assigned.
+func (c *Consumer) IsPaused(topic string, partition int32) bool {
+	return c.pauser.IsPaused(topic, partition)
+}
+
+// syncPauses applies the pause state to the current consumer group, if any.
+func (c *Consumer) syncPauses() {
+	c.clientMutex.Lock()
+	defer c.clientMutex.Unlock()
+	if c.consumerGroup != nil {
+		c.pauser.Sync(*c.consumerGroup)
+	}
+}
+
+// GetOffsetStats returns the commit progress per partition of the current session.
+func (c *Consumer) GetOffsetStats() map[shared.TopicPartition]shared.OffsetStats {
+	return c.offsets.Stats()
+}
+
 // GetStats returns consumed message counts.
 func (c *Consumer) GetStats() (uint64, uint64) {
 	return c.marked.Load(), c.read.Load()
@@ -302,9 +562,33 @@ func (c *Consumer) GetTopics() []string {
 	return topics
 }
 
-// GetMessage returns the next message from the consumer.
-func (c *Consumer) GetMessage() *shared.KafkaMessage {
-	return <-c.incomingMessages
+// GetMessage blocks until a message is available and returns it.
+// It returns ctx.Err() if ctx is done first, or ErrConsumerClosed once the consumer is closed.
+func (c *Consumer) GetMessage(ctx context.Context) (*shared.KafkaMessage, error) {
+	select {
+	case msg := <-c.incomingMessages:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, ErrConsumerClosed
+	}
+}
+
+// Run passes consumed messages to handler on a pool of workers and marks them if handler returns nil.
+// It returns once ctx is done or the consumer is closed, see shared.Run for the ordering guarantees.
+// Every call of handler is traced in a process span continuing the trace of the message.
+func (c *Consumer) Run(ctx context.Context, handler shared.Handler, opts shared.RunOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return shared.Run(ctx, c.incomingMessages, c.MarkMessage, c.options.TraceHandler(handler), opts)
 }
 
 // GetMessages returns the channel of messages from the consumer.
@@ -313,24 +597,56 @@ func (c *Consumer) GetMessages() <-chan *shared.KafkaMessage {
 }
 
 // MarkMessage marks a message as processed.
+// Messages marked after the consumer has been closed are dropped.
+// Offsets are committed up to the oldest unmarked message of the partition, so a consumed message that is never
+// marked stops the commits of its partition until the next session, see GetOffsetStats.
 func (c *Consumer) MarkMessage(message *shared.KafkaMessage) {
-	c.messagesToMarkChan <- message
+	select {
+	case c.messagesToMarkChan <- message:
+	case <-c.done:
+	}
 }
 
 // MarkMessages marks a slice of messages as processed.
 func (c *Consumer) MarkMessages(messages []*shared.KafkaMessage) {
 	for _, message := range messages {
-		c.messagesToMarkChan <- message
+		c.MarkMessage(message)
 	}
 }
 
 // IsReady returns whether the consumer is ready to consume messages.
+// It is false while the consumer has no consumer group session, for example during a rebalance or reconnect.
 func (c *Consumer) IsReady() bool {
 	return c.isReady.Load()
 }
 
+// Health returns the state of the consumer for health and readiness probes.
+// The consumer reconnects on its own, so it only becomes unhealthy once it is closed.
+func (c *Consumer) Health() shared.Health {
+	c.clientMutex.Lock()
+	var client sarama.Client
+	if c.client != nil {
+		client = *c.client
+	}
+	health := shared.Health{
+		Healthy:          !c.isClosing(),
+		Ready:            c.isReady.Load(),
+		ConnectedBrokers: shared.ConnectedBrokers(client),
+		LastFetch:        c.activity.LastFetch(),
+		LastCommit:       c.activity.LastCommit(),
+	}
+	c.clientMutex.Unlock()
+	switch {
+	case !health.Healthy:
+		health.Reason = "consumer closed"
+	case !health.Ready:
+		health.Reason = "no consumer group session"
+	}
+	return health
+}
+
 // filter applies regular expression filters to a list of topics and returns the filtered list.
-func filter(topics []string, regexes []*regexp.Regexp) []string {
+func filter(topics []string, regexes []*regexp.Regexp, log *zap.SugaredLogger) []string {
 	filtered := make(map[string]bool)
 	for _, topic := range topics {
 		for _, re := range regexes {
@@ -344,7 +660,7 @@ func filter(topics []string, regexes []*regexp.Regexp) []string {
 	for topic := range filtered {
 		result = append(result, topic)
 	}
-	zap.S().Debugf("Filtered topics: %v to %v", topics, result)
+	log.Debugf("Filtered topics: %v to %v", topics, result)
 	slices.Sort(result)
 	return result
 }
@@ -0,0 +1,314 @@
+package redpanda
+
+import (
+	"context"
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTopic = "umh.v1.redpanda.test"
+
+func newMockCluster(t *testing.T, fetchResponse *sarama.MockFetchResponse) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 0)
+	broker.SetHandlerByMap(mockResponses(t, broker, fetchResponse))
+	return broker
+}
+
+func mockResponses(t *testing.T, broker *sarama.MockBroker, fetchResponse *sarama.MockFetchResponse) map[string]sarama.MockResponse {
+	return map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(testTopic, 0, sarama.OffsetOldest, 0).
+			SetOffset(testTopic, 0, sarama.OffsetNewest, 2),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "test-group", broker),
+		"HeartbeatRequest": sarama.NewMockHeartbeatResponse(t),
+		"JoinGroupRequest": sarama.NewMockJoinGroupResponse(t).
+			SetGroupProtocol(sarama.RangeBalanceStrategyName),
+		"SyncGroupRequest": sarama.NewMockSyncGroupResponse(t).SetMemberAssignment(
+			&sarama.ConsumerGroupMemberAssignment{
+				Version: 0,
+				Topics: map[string][]int32{
+					testTopic: {0},
+				},
+			}),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("test-group", testTopic, 0, 0, "", sarama.ErrNoError).
+			SetError(sarama.ErrNoError),
+		"FetchRequest":        fetchResponse,
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+		"LeaveGroupRequest":   sarama.NewMockLeaveGroupResponse(t),
+	}
+}
+
+func TestCloseCommitsMarkedMessages(t *testing.T) {
+	broker := newMockCluster(t, sarama.NewMockFetchResponse(t, 2).
+		SetMessage(testTopic, 0, 0, sarama.StringEncoder("foo")).
+		SetMessage(testTopic, 0, 1, sarama.StringEncoder("bar")).
+		SetHighWaterMark(testTopic, 0, 2))
+	defer broker.Close()
+
+	consumer, err := NewConsumer([]string{broker.Addr()}, []string{"umh.v1.*"}, "test-group", "test-1", sarama.OffsetOldest,
+		shared.WithSaramaConfig(func(config *sarama.Config) {
+			config.Consumer.Offsets.AutoCommit.Enable = false
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last *shared.KafkaMessage
+	for i := 0; i < 2; i++ {
+		select {
+		case last = <-consumer.GetMessages():
+			consumer.MarkMessage(last)
+		case <-time.After(10 * time.Second):
+			t.Fatal("no message received")
+		}
+	}
+
+	ctx, cncl := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cncl()
+	if err = consumer.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-consumer.Done():
+	default:
+		t.Fatal("expected Done to be closed after Close")
+	}
+
+	committed := int64(-1)
+	for _, request := range broker.History() {
+		if commit, ok := request.Request.(*sarama.OffsetCommitRequest); ok {
+			if offset, _, err := commit.Offset(testTopic, 0); err == nil {
+				committed = offset
+			}
+		}
+	}
+	if committed != last.Offset+1 {
+		t.Fatalf("expected committed offset %d, got %d", last.Offset+1, committed)
+	}
+
+	if marked, read := consumer.GetStats(); marked != 2 || read != 2 {
+		t.Fatalf("expected 2 marked and 2 read messages, got %d and %d", marked, read)
+	}
+}
+
+func TestRebalanceListener(t *testing.T) {
+	fetchResponse := sarama.NewMockFetchResponse(t, 1).
+		SetMessage(testTopic, 0, 0, sarama.StringEncoder("foo")).
+		SetHighWaterMark(testTopic, 0, 1)
+	broker := newMockCluster(t, fetchResponse)
+	defer broker.Close()
+
+	assigned := make(chan shared.Assignment, 10)
+	revoked := make(chan shared.Assignment, 10)
+	lost := make(chan shared.Assignment, 10)
+	consumer, err := NewConsumer([]string{broker.Addr()}, []string{"umh.v1.*"}, "test-group", "test-1", sarama.OffsetOldest,
+		shared.WithRebalanceListener(shared.RebalanceListener{
+			OnPartitionsAssigned: func(assignment shared.Assignment) { assigned <- assignment },
+			OnPartitionsRevoked:  func(assignment shared.Assignment) { revoked <- assignment },
+			OnPartitionsLost:     func(assignment shared.Assignment) { lost <- assignment },
+		}),
+		shared.WithSaramaConfig(func(config *sarama.Config) {
+			config.Consumer.Group.Heartbeat.Interval = 100 * time.Millisecond
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-consumer.GetMessages():
+	case <-time.After(10 * time.Second):
+		t.Fatal("no message received")
+	}
+	select {
+	case assignment := <-assigned:
+		if len(assignment.Claims[testTopic]) != 1 || assignment.Claims[testTopic][0] != 0 {
+			t.Fatalf("unexpected assignment %+v", assignment)
+		}
+	default:
+		t.Fatal("expected partitions to be assigned before the first message")
+	}
+
+	// A heartbeat rejecting the member ends the session and the partitions are lost.
+	responses := mockResponses(t, broker, fetchResponse)
+	responses["HeartbeatRequest"] = sarama.NewMockWrapper(&sarama.HeartbeatResponse{Version: 3, Err: sarama.ErrUnknownMemberId})
+	broker.SetHandlerByMap(responses)
+	select {
+	case <-lost:
+	case <-revoked:
+		t.Fatal("expected partitions to be lost, not revoked")
+	case <-time.After(10 * time.Second):
+		t.Fatal("partitions were not lost")
+	}
+
+	ctx, cncl := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cncl()
+	_ = consumer.Close(ctx)
+}
+
+func TestSeekToOffset(t *testing.T) {
+	broker := newMockCluster(t, sarama.NewMockFetchResponse(t, 2).
+		SetMessage(testTopic, 0, 0, sarama.StringEncoder("foo")).
+		SetMessage(testTopic, 0, 1, sarama.StringEncoder("bar")).
+		SetHighWaterMark(testTopic, 0, 2))
+	defer broker.Close()
+
+	consumer, err := NewConsumer([]string{broker.Addr()}, []string{"umh.v1.*"}, "test-group", "test-1", sarama.OffsetOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close(context.Background())
+
+	receive := func() *shared.KafkaMessage {
+		select {
+		case msg := <-consumer.GetMessages():
+			return msg
+		case <-time.After(10 * time.Second):
+			t.Fatal("no message received")
+			return nil
+		}
+	}
+	for i := 0; i < 2; i++ {
+		consumer.MarkMessage(receive())
+	}
+
+	consumer.SeekToOffset(testTopic, 0, 1)
+	if msg := receive(); msg.Offset != 1 {
+		t.Fatalf("expected offset 1 after seeking, got %d", msg.Offset)
+	}
+
+	reset := false
+	for _, request := range broker.History() {
+		if commit, ok := request.Request.(*sarama.OffsetCommitRequest); ok {
+			if offset, _, err := commit.Offset(testTopic, 0); err == nil && offset == 1 {
+				reset = true
+			}
+		}
+	}
+	if !reset {
+		t.Fatal("expected the seek to be committed")
+	}
+}
+
+type markingSession struct {
+	sarama.ConsumerGroupSession
+	ctx     context.Context
+	marked  map[string]int64
+	commits atomic.Int32
+}
+
+func (s *markingSession) MarkOffset(topic string, partition int32, offset int64, _ string) {
+	s.marked[fmt.Sprintf("%s/%d", topic, partition)] = offset
+}
+
+func (s *markingSession) Commit() {
+	s.commits.Add(1)
+}
+
+func (s *markingSession) Context() context.Context {
+	return s.ctx
+}
+
+type offsetRecorder struct {
+	shared.NoopMetricsRecorder
+	mutex     sync.Mutex
+	marked    map[string]int64
+	committed map[string]int64
+}
+
+func newOffsetRecorder() *offsetRecorder {
+	return &offsetRecorder{marked: make(map[string]int64), committed: make(map[string]int64)}
+}
+
+func (r *offsetRecorder) OffsetMarked(topic string, partition int32, offset int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.marked[fmt.Sprintf("%s/%d", topic, partition)] = offset
+}
+
+func (r *offsetRecorder) OffsetCommitted(topic string, partition int32, offset int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.committed[fmt.Sprintf("%s/%d", topic, partition)] = offset
+}
+
+func (r *offsetRecorder) committedOffset(key string) int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.committed[key]
+}
+
+func newMarkingHandler(t *testing.T, recorder *offsetRecorder) *ConsumerGroupHandler {
+	options, err := shared.NewConfig(shared.Config{}, shared.WithMetrics(recorder),
+		shared.WithSaramaConfig(func(config *sarama.Config) {
+			config.Consumer.Offsets.AutoCommit.Interval = 10 * time.Millisecond
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ConsumerGroupHandler{
+		marked:   &atomic.Uint64{},
+		options:  options,
+		offsets:  shared.NewOffsetTracker(),
+		activity: &shared.Activity{},
+	}
+}
+
+func TestMarkReportsMarkedOffsets(t *testing.T) {
+	recorder := newOffsetRecorder()
+	handler := newMarkingHandler(t, recorder)
+	session := &markingSession{marked: make(map[string]int64)}
+	message := &shared.KafkaMessage{Topic: "topic", Partition: 2, Offset: 7}
+	handler.offsets.Track(message)
+	handler.mark(session, message)
+	// The tracker dropped this offset, so the mark is neither applied nor counted.
+	handler.mark(session, &shared.KafkaMessage{Topic: "topic", Partition: 2, Offset: 9})
+
+	if session.marked["topic/2"] != 8 || recorder.marked["topic/2"] != 8 {
+		t.Fatalf("expected offset 8 to be marked and reported, got %v and %v", session.marked, recorder.marked)
+	}
+	if len(recorder.committed) != 0 || !handler.activity.LastCommit().IsZero() {
+		t.Fatalf("expected nothing to be reported as committed before a commit, got %v", recorder.committed)
+	}
+	if marked := handler.marked.Load(); marked != 1 {
+		t.Fatalf("expected 1 marked message, got %d", marked)
+	}
+}
+
+func TestAutoCommitReportsCommittedOffsets(t *testing.T) {
+	recorder := newOffsetRecorder()
+	handler := newMarkingHandler(t, recorder)
+	ctx, cncl := context.WithCancel(context.Background())
+	session := &markingSession{ctx: ctx, marked: make(map[string]int64)}
+	message := &shared.KafkaMessage{Topic: "topic", Partition: 2, Offset: 7}
+	handler.offsets.Track(message)
+	handler.mark(session, message)
+
+	done := make(chan struct{})
+	go func() {
+		handler.autoCommit(session)
+		close(done)
+	}()
+	deadline := time.After(10 * time.Second)
+	for recorder.committedOffset("topic/2") != 8 {
+		select {
+		case <-deadline:
+			t.Fatal("the auto-commit was not reported")
+		case <-time.After(shared.CycleTime):
+		}
+	}
+	if session.commits.Load() == 0 {
+		t.Fatal("expected the offsets to be committed before they are reported")
+	}
+	cncl()
+	<-done
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
 	"go.uber.org/zap"
 	"sync/atomic"
+	"time"
 )
 
 // ConsumerGroupHandler represents a Sarama consumer group consumer
@@ -15,10 +16,36 @@ type ConsumerGroupHandler struct {
 	messagesToMarkChan chan *shared.KafkaMessage
 	read               *atomic.Uint64
 	marked             *atomic.Uint64
+	// closing is closed when the consumer shuts down, the handler then flushes pending marks and commits.
+	closing <-chan struct{}
+	// options controls how sarama messages are converted.
+	options *shared.Config
+	// offsets tracks consumed and marked offsets across all claims of the session.
+	offsets *shared.OffsetTracker
+	// groupId is reported with rebalance metrics and notifications.
+	groupId string
+	// client is used to tell revoked from lost partitions and to resolve seeks.
+	client sarama.Client
+	// seeker holds seeks that are applied in Setup.
+	seeker *shared.Seeker
+	// pauser holds paused partitions, which are applied to group whenever a claim starts.
+	pauser *shared.Pauser
+	// group is the consumer group running the session.
+	group sarama.ConsumerGroup
+	// activity records consumed messages and commits for health reporting.
+	activity *shared.Activity
+	// log carries the group ID, sessionLog adds member ID and generation of the current session.
+	log        *zap.SugaredLogger
+	sessionLog *zap.SugaredLogger
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
 func (c *ConsumerGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	// sarama calls Cleanup even if Setup fails, so the session logger is set first.
+	if c.log == nil {
+		return errors.New("ConsumerGroupHandler: logger is nil")
+	}
+	c.sessionLog = c.log.With("member_id", session.MemberID(), "generation", session.GenerationID())
 	if c.ready == nil {
 		return errors.New("ConsumerGroupHandler: ready channel is nil")
 	}
@@ -34,15 +61,46 @@ func (c *ConsumerGroupHandler) Setup(session sarama.ConsumerGroupSession) error
 	if c.marked == nil {
 		return errors.New("ConsumerGroupHandler: marked counter is nil")
 	}
+	if c.options == nil {
+		return errors.New("ConsumerGroupHandler: options are nil")
+	}
+	if c.offsets == nil {
+		return errors.New("ConsumerGroupHandler: offset tracker is nil")
+	}
+	if c.seeker == nil {
+		return errors.New("ConsumerGroupHandler: seeker is nil")
+	}
+	if c.pauser == nil {
+		return errors.New("ConsumerGroupHandler: pauser is nil")
+	}
+	if c.activity == nil {
+		return errors.New("ConsumerGroupHandler: activity is nil")
+	}
+
+	// Offsets of the previous session are redelivered, marks for them can no longer be committed.
+	c.offsets.Reset()
+	c.seeker.Apply(session, c.client, c.sessionLog)
+	c.pauser.SetClaims(session.Claims())
+	c.options.Metrics.Rebalance(c.groupId)
+	c.options.NotifyAssigned(session)
+	if c.options.Sarama.Consumer.Offsets.AutoCommit.Enable {
+		go c.autoCommit(session)
+	}
 
 	c.ready.Store(true)
-	zap.S().Debugf("ConsumerGroupHandler set up for: %+v", session.Claims())
+	c.sessionLog.Debugf("ConsumerGroupHandler set up for: %+v", session.Claims())
 	return nil
 }
 
 // Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
 func (c *ConsumerGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
-	zap.S().Debugf("ConsumerGroupHandler cleaned up")
+	c.ready.Store(false)
+	c.pauser.SetClaims(nil)
+	if c.options.RebalanceListener != nil && !c.options.NotifyRevoked(c.client, c.groupId, session) {
+		// Commit what the listener marked while the partitions were revoked.
+		c.flush(session)
+	}
+	c.sessionLog.Debugf("ConsumerGroupHandler cleaned up")
 	return nil
 }
 
@@ -50,35 +108,113 @@ func (c *ConsumerGroupHandler) Cleanup(session sarama.ConsumerGroupSession) erro
 // Once the Messages() channel is closed, the Handler must finish its processing
 // loop and exit.
 func (c *ConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	zap.S().Debugf("ConsumerGroupHandler: starting to consume claim: %+v", claim)
+	log := c.sessionLog.With("topic", claim.Topic(), "partition", claim.Partition())
+	log.Debugf("ConsumerGroupHandler: starting to consume claim: %+v", claim)
+	// sarama does not keep pauses of partitions it reassigns, so they are applied once the claim exists.
+	c.pauser.Sync(c.group)
 	for {
 		select {
 		case message, ok := <-claim.Messages():
 			if !ok {
-				zap.S().Infof("ConsumerGroupHandler: Message channel closed")
+				log.Infof("ConsumerGroupHandler: Message channel closed")
 				return nil
 			}
-			c.incomingMessages <- &shared.KafkaMessage{
-				Topic:     message.Topic,
-				Partition: message.Partition,
-				Offset:    message.Offset,
-				Key:       message.Key,
-				Value:     message.Value,
+			msg := c.options.FromConsumerMessage(message)
+			c.offsets.Track(msg)
+			select {
+			case c.incomingMessages <- msg:
+				c.read.Add(1)
+				c.activity.Fetched()
+				c.options.Metrics.MessageConsumed(message.Topic, message.Partition)
+				c.options.Metrics.ConsumerLag(message.Topic, message.Partition, claim.HighWaterMarkOffset()-message.Offset-1)
+			case <-c.closing:
+				c.flush(session)
+				return nil
+			case <-session.Context().Done():
+				log.Infof("ConsumerGroupHandler: Session context closed")
+				return nil
 			}
-			c.read.Add(1)
 		case msg := <-c.messagesToMarkChan:
-			session.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, "")
-			c.marked.Add(1)
+			c.mark(session, msg)
+		case <-c.closing:
+			c.flush(session)
+			return nil
+		case <-c.seeker.Restart():
+			// Returning ends the session, the seeks are applied in the next Setup.
+			log.Infof("ConsumerGroupHandler: Ending session to apply seeks")
+			c.flush(session)
+			return nil
 		// Should return when `session.Context()` is done.
 		// If not, will raise `ErrRebalanceInProgress` or `read tcp <ip>:<port>: i/o timeout` when kafka rebalances. see:
 		// https://github.com/IBM/sarama/issues/1192
 		case _, ok := <-session.Context().Done():
 			if !ok {
-				zap.S().Infof("ConsumerGroupHandler: Session context channel closed")
+				log.Infof("ConsumerGroupHandler: Session context channel closed")
 				return nil
 			}
-			zap.S().Infof("ConsumerGroupHandler: Session context closed")
+			log.Infof("ConsumerGroupHandler: Session context closed")
 			return nil
 		}
 	}
 }
+
+// mark acknowledges msg and marks the offset after the last contiguously processed message of its partition.
+func (c *ConsumerGroupHandler) mark(session sarama.ConsumerGroupSession, msg *shared.KafkaMessage) {
+	if msg == nil {
+		return
+	}
+	// Marks of offsets the tracker dropped, for example after a rebalance, are not counted.
+	if !c.offsets.Ack(msg) {
+		return
+	}
+	c.marked.Add(1)
+	c.options.Metrics.MessageMarked(msg.Topic, msg.Partition)
+	offset, ok := c.offsets.CommittableOffset(msg.Topic, msg.Partition)
+	if !ok {
+		return
+	}
+	session.MarkOffset(msg.Topic, msg.Partition, offset, "")
+	c.options.Metrics.OffsetMarked(msg.Topic, msg.Partition, offset)
+}
+
+// autoCommit commits the marked offsets every auto-commit interval until the session ends.
+// sarama reports nothing about its background commits, committing here reports them once they returned,
+// and leaves nothing for sarama's own auto-commit.
+func (c *ConsumerGroupHandler) autoCommit(session sarama.ConsumerGroupSession) {
+	ticker := time.NewTicker(c.options.Sarama.Consumer.Offsets.AutoCommit.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.commit(session)
+		case <-session.Context().Done():
+			return
+		}
+	}
+}
+
+// flush marks all pending messages and commits them synchronously.
+func (c *ConsumerGroupHandler) flush(session sarama.ConsumerGroupSession) {
+	c.sessionLog.Infof("ConsumerGroupHandler: Flushing marked messages")
+	for {
+		select {
+		case msg := <-c.messagesToMarkChan:
+			c.mark(session, msg)
+		default:
+			c.commit(session)
+			return
+		}
+	}
+}
+
+// commit commits the marked offsets synchronously and reports them.
+func (c *ConsumerGroupHandler) commit(session sarama.ConsumerGroupSession) {
+	committable := c.offsets.Committable()
+	now := time.Now()
+	session.Commit()
+	c.activity.Committed()
+	c.options.Metrics.CommitDuration(time.Since(now))
+	for tp, offset := range committable {
+		c.options.Metrics.OffsetCommitted(tp.Topic, tp.Partition, offset)
+	}
+}
@@ -0,0 +1,325 @@
+// Package dlq routes messages that failed processing to retry topics and finally to a dead-letter topic.
+//
+// A message that is nacked for the first time is re-published to the first retry topic, for example
+// "orders.retry.5s", the second time to the next tier, for example "orders.retry.1m", and once all tiers
+// are exhausted to the dead-letter topic "orders.dlq". The consumer subscribes to the retry topics as well,
+// passes its messages through DeadLetterQueue.Delay, which holds back retried messages until they are due,
+// and wraps its handler with DeadLetterQueue.Handler:
+//
+//	messages := queue.Delay(ctx, consumer.GetMessages(), consumer)
+//	err := shared.Run(ctx, messages, consumer.MarkMessage, queue.Handler(handler), shared.RunOptions{Workers: 4})
+package dlq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/producer"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers set on re-published messages.
+const (
+	// HeaderError holds the error message of the last failed attempt.
+	HeaderError = "x-dlq-error"
+	// HeaderAttempt holds the number of failed attempts.
+	HeaderAttempt = "x-dlq-attempt"
+	// HeaderOriginalTopic holds the topic the message was originally consumed from.
+	HeaderOriginalTopic = "x-dlq-original-topic"
+	// HeaderOriginalPartition holds the partition the message was originally consumed from.
+	HeaderOriginalPartition = "x-dlq-original-partition"
+	// HeaderOriginalOffset holds the offset the message originally had.
+	HeaderOriginalOffset = "x-dlq-original-offset"
+	// HeaderRetryAt holds the time in unix milliseconds after which the message is due for its next attempt.
+	HeaderRetryAt = "x-dlq-retry-at"
+)
+
+// DefaultRetryDelays are the delay tiers used if none are configured.
+var DefaultRetryDelays = []time.Duration{5 * time.Second, time.Minute}
+
+// Publisher publishes a message and waits for the broker to acknowledge it, it is implemented by producer.Producer.
+type Publisher interface {
+	SendMessageSync(ctx context.Context, message *shared.KafkaMessage) (int32, int64, error)
+}
+
+var _ Publisher = (*producer.Producer)(nil)
+
+// Config configures a DeadLetterQueue.
+type Config struct {
+	// RetryDelays are the delay tiers, one retry topic is used per tier. An empty slice disables retries.
+	// It defaults to DefaultRetryDelays if nil.
+	RetryDelays []time.Duration
+	// RetrySuffix is inserted between the original topic and the delay, it defaults to ".retry.".
+	RetrySuffix string
+	// DLQSuffix is appended to the original topic to name the dead-letter topic, it defaults to ".dlq".
+	DLQSuffix string
+}
+
+// DeadLetterQueue re-publishes failed messages to retry and dead-letter topics.
+type DeadLetterQueue struct {
+	publisher   Publisher
+	retryDelays []time.Duration
+	retrySuffix string
+	dlqSuffix   string
+}
+
+// New returns a DeadLetterQueue publishing with publisher.
+func New(publisher Publisher, config Config) (*DeadLetterQueue, error) {
+	if publisher == nil {
+		return nil, errors.New("publisher must not be nil")
+	}
+	delays := config.RetryDelays
+	if delays == nil {
+		delays = DefaultRetryDelays
+	}
+	for _, delay := range delays {
+		if delay <= 0 {
+			return nil, fmt.Errorf("invalid retry delay %s", delay)
+		}
+	}
+	if config.RetrySuffix == "" {
+		config.RetrySuffix = ".retry."
+	}
+	if config.DLQSuffix == "" {
+		config.DLQSuffix = ".dlq"
+	}
+	return &DeadLetterQueue{
+		publisher:   publisher,
+		retryDelays: append([]time.Duration(nil), delays...),
+		retrySuffix: config.RetrySuffix,
+		dlqSuffix:   config.DLQSuffix,
+	}, nil
+}
+
+// RetryTopic returns the retry topic of topic for the given delay tier.
+func (d *DeadLetterQueue) RetryTopic(topic string, delay time.Duration) string {
+	return topic + d.retrySuffix + formatDelay(delay)
+}
+
+// DLQTopic returns the dead-letter topic of topic.
+func (d *DeadLetterQueue) DLQTopic(topic string) string {
+	return topic + d.dlqSuffix
+}
+
+// RetryTopics returns the retry topics of topic, a consumer of topic must subscribe to them as well.
+func (d *DeadLetterQueue) RetryTopics(topic string) []string {
+	topics := make([]string, 0, len(d.retryDelays))
+	for _, delay := range d.retryDelays {
+		topics = append(topics, d.RetryTopic(topic, delay))
+	}
+	return topics
+}
+
+// Nack re-publishes message after a failed attempt and returns the topic it was published to.
+// The message goes to the retry topic of the next delay tier, or to the dead-letter topic once all tiers are exhausted.
+// The original message must be marked once Nack returned without error.
+func (d *DeadLetterQueue) Nack(ctx context.Context, message *shared.KafkaMessage, cause error) (string, error) {
+	if message == nil {
+		return "", errors.New("message must not be nil")
+	}
+
+	failed := &shared.KafkaMessage{
+		Topic:   message.Topic,
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: make(map[string]string, len(message.Headers)+6),
+	}
+	for k, v := range message.Headers {
+		failed.Headers[k] = v
+	}
+	// Only the first hop records where the message came from.
+	if _, ok := failed.Headers[HeaderOriginalTopic]; !ok {
+		failed.Headers[HeaderOriginalTopic] = message.Topic
+		failed.Headers[HeaderOriginalPartition] = strconv.FormatInt(int64(message.Partition), 10)
+		failed.Headers[HeaderOriginalOffset] = strconv.FormatInt(message.Offset, 10)
+	}
+	originalTopic := failed.Headers[HeaderOriginalTopic]
+
+	attempt := Attempt(message) + 1
+	failed.Headers[HeaderAttempt] = strconv.Itoa(attempt)
+	if cause != nil {
+		failed.Headers[HeaderError] = cause.Error()
+	} else {
+		delete(failed.Headers, HeaderError)
+	}
+
+	if attempt <= len(d.retryDelays) {
+		delay := d.retryDelays[attempt-1]
+		failed.Topic = d.RetryTopic(originalTopic, delay)
+		failed.Headers[HeaderRetryAt] = strconv.FormatInt(time.Now().Add(delay).UnixMilli(), 10)
+	} else {
+		failed.Topic = d.DLQTopic(originalTopic)
+		delete(failed.Headers, HeaderRetryAt)
+	}
+
+	if _, _, err := d.publisher.SendMessageSync(ctx, failed); err != nil {
+		return "", fmt.Errorf("failed to publish message to %s: %w", failed.Topic, err)
+	}
+	return failed.Topic, nil
+}
+
+// Delay forwards messages until ctx is done or messages is closed, holding back retried messages until they are due.
+// A held message pauses its partition on pauser, which may be nil, and later messages of the partition are held
+// behind it, so other partitions keep flowing. The partition is resumed once its held messages are forwarded,
+// which also lifts a pause set on it by the application. Messages that are still held when Delay stops stay
+// unmarked and are redelivered. The returned channel is closed once Delay stops.
+func (d *DeadLetterQueue) Delay(ctx context.Context, messages <-chan *shared.KafkaMessage, pauser shared.Pausable) <-chan *shared.KafkaMessage {
+	out := make(chan *shared.KafkaMessage)
+	go func() {
+		defer close(out)
+		held := make(map[shared.TopicPartition][]*shared.KafkaMessage)
+		var ready []*shared.KafkaMessage
+		var timer *time.Timer
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+		for {
+			var due <-chan time.Time
+			if timer != nil {
+				timer.Stop()
+				timer = nil
+			}
+			if next := nextRetryAt(held); !next.IsZero() {
+				timer = time.NewTimer(time.Until(next))
+				due = timer.C
+			}
+			var send chan<- *shared.KafkaMessage
+			var first *shared.KafkaMessage
+			if len(ready) > 0 {
+				send = out
+				first = ready[0]
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+				if message == nil {
+					continue
+				}
+				partition := shared.TopicPartition{Topic: message.Topic, Partition: message.Partition}
+				if queued, ok := held[partition]; ok {
+					held[partition] = append(queued, message)
+					continue
+				}
+				if time.Until(RetryAt(message)) <= 0 {
+					ready = append(ready, message)
+					continue
+				}
+				held[partition] = []*shared.KafkaMessage{message}
+				if pauser != nil {
+					pauser.Pause(map[string][]int32{partition.Topic: {partition.Partition}})
+				}
+			case send <- first:
+				ready = ready[1:]
+			case <-due:
+				for partition, queued := range held {
+					for len(queued) > 0 && time.Until(RetryAt(queued[0])) <= 0 {
+						ready = append(ready, queued[0])
+						queued = queued[1:]
+					}
+					if len(queued) > 0 {
+						held[partition] = queued
+						continue
+					}
+					delete(held, partition)
+					if pauser != nil {
+						pauser.Resume(map[string][]int32{partition.Topic: {partition.Partition}})
+					}
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// nextRetryAt returns the earliest time one of the held partitions is due, or the zero time if none is held.
+func nextRetryAt(held map[shared.TopicPartition][]*shared.KafkaMessage) time.Time {
+	var next time.Time
+	for _, queued := range held {
+		if at := RetryAt(queued[0]); next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
+
+// Handler wraps next so that retried messages are delayed until they are due and failed messages are nacked.
+// Waiting for a message blocks the worker of shared.Run and every partition handled by it, pass the messages
+// through Delay first so they only reach the handler once they are due.
+// The returned handler only returns an error if the message could neither be processed nor re-published,
+// so the message stays unmarked and is redelivered.
+func (d *DeadLetterQueue) Handler(next shared.Handler) shared.Handler {
+	return func(ctx context.Context, message *shared.KafkaMessage) error {
+		if wait := time.Until(RetryAt(message)); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		err := next(ctx, message)
+		if err == nil {
+			return nil
+		}
+		if _, nackErr := d.Nack(ctx, message, err); nackErr != nil {
+			return errors.Join(err, nackErr)
+		}
+		return nil
+	}
+}
+
+// Attempt returns the number of failed attempts recorded on message.
+func Attempt(message *shared.KafkaMessage) int {
+	ok, value := shared.GetSHeader(message, HeaderAttempt)
+	if !ok {
+		return 0
+	}
+	attempt, err := strconv.Atoi(value)
+	if err != nil || attempt < 0 {
+		return 0
+	}
+	return attempt
+}
+
+// RetryAt returns the time after which message is due for its next attempt, or the zero time if it is due immediately.
+func RetryAt(message *shared.KafkaMessage) time.Time {
+	ok, value := shared.GetSHeader(message, HeaderRetryAt)
+	if !ok {
+		return time.Time{}
+	}
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis)
+}
+
+// formatDelay formats delay in its largest whole unit, for example "5s", "1m" or "250ms".
+func formatDelay(delay time.Duration) string {
+	units := []struct {
+		unit   time.Duration
+		suffix string
+	}{
+		{time.Hour, "h"},
+		{time.Minute, "m"},
+		{time.Second, "s"},
+		{time.Millisecond, "ms"},
+	}
+	for _, u := range units {
+		if delay%u.unit == 0 {
+			return strconv.FormatInt(int64(delay/u.unit), 10) + u.suffix
+		}
+	}
+	return strings.ReplaceAll(delay.String(), ".", "_")
+}
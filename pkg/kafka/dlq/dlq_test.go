@@ -0,0 +1,146 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingPublisher struct {
+	messages []*shared.KafkaMessage
+}
+
+func (p *recordingPublisher) SendMessageSync(_ context.Context, message *shared.KafkaMessage) (int32, int64, error) {
+	p.messages = append(p.messages, message)
+	return 0, int64(len(p.messages) - 1), nil
+}
+
+func TestNackWalksRetryTiers(t *testing.T) {
+	publisher := &recordingPublisher{}
+	queue, err := New(publisher, Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"orders.retry.5s", "orders.retry.1m"}, queue.RetryTopics("orders"))
+
+	message := &shared.KafkaMessage{
+		Topic:     "orders",
+		Partition: 3,
+		Offset:    42,
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+		Headers:   map[string]string{"x-origin": "origin"},
+	}
+	expected := []string{"orders.retry.5s", "orders.retry.1m", "orders.dlq"}
+	for i, topic := range expected {
+		published, err := queue.Nack(context.Background(), message, errors.New("boom"))
+		assert.NoError(t, err)
+		assert.Equal(t, topic, published)
+
+		message = publisher.messages[i]
+		assert.Equal(t, topic, message.Topic)
+		assert.Equal(t, []byte("value"), message.Value)
+		assert.Equal(t, i+1, Attempt(message))
+		assert.Equal(t, "boom", message.Headers[HeaderError])
+		assert.Equal(t, "orders", message.Headers[HeaderOriginalTopic])
+		assert.Equal(t, "3", message.Headers[HeaderOriginalPartition])
+		assert.Equal(t, "42", message.Headers[HeaderOriginalOffset])
+		assert.Equal(t, "origin", message.Headers["x-origin"])
+
+		// Pretend the message was consumed from the retry topic.
+		message.Partition = 0
+		message.Offset = int64(i)
+	}
+	assert.True(t, RetryAt(publisher.messages[0]).After(time.Now()))
+	assert.True(t, RetryAt(publisher.messages[2]).IsZero())
+}
+
+func TestHandlerNacksFailedMessages(t *testing.T) {
+	publisher := &recordingPublisher{}
+	queue, err := New(publisher, Config{RetryDelays: []time.Duration{50 * time.Millisecond}})
+	assert.NoError(t, err)
+
+	calls := 0
+	handler := queue.Handler(func(_ context.Context, _ *shared.KafkaMessage) error {
+		calls++
+		return errors.New("boom")
+	})
+
+	assert.NoError(t, handler(context.Background(), &shared.KafkaMessage{Topic: "orders"}))
+	assert.Len(t, publisher.messages, 1)
+	assert.Equal(t, "orders.retry.50ms", publisher.messages[0].Topic)
+
+	start := time.Now()
+	assert.NoError(t, handler(context.Background(), publisher.messages[0]))
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "orders.dlq", publisher.messages[1].Topic)
+}
+
+type recordingPauser struct {
+	mutex   sync.Mutex
+	paused  []map[string][]int32
+	resumed []map[string][]int32
+}
+
+func (p *recordingPauser) Pause(partitions map[string][]int32) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.paused = append(p.paused, partitions)
+}
+
+func (p *recordingPauser) Resume(partitions map[string][]int32) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.resumed = append(p.resumed, partitions)
+}
+
+func TestDelayDoesNotBlockOtherPartitions(t *testing.T) {
+	queue, err := New(&recordingPublisher{}, Config{})
+	assert.NoError(t, err)
+	pauser := &recordingPauser{}
+
+	retryAt := time.Now().Add(200 * time.Millisecond)
+	retried := func(offset int64) *shared.KafkaMessage {
+		return &shared.KafkaMessage{
+			Topic:   "orders.retry.5s",
+			Offset:  offset,
+			Headers: map[string]string{HeaderRetryAt: strconv.FormatInt(retryAt.UnixMilli(), 10)},
+		}
+	}
+	messages := make(chan *shared.KafkaMessage, 3)
+	messages <- retried(0)
+	messages <- retried(1)
+	messages <- &shared.KafkaMessage{Topic: "orders", Partition: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handled := make(chan *shared.KafkaMessage, 3)
+	handler := queue.Handler(func(_ context.Context, message *shared.KafkaMessage) error {
+		handled <- message
+		return nil
+	})
+	go func() {
+		// A single worker handles both partitions.
+		_ = shared.Run(ctx, queue.Delay(ctx, messages, pauser), func(*shared.KafkaMessage) {}, handler, shared.RunOptions{Workers: 1})
+	}()
+
+	message := <-handled
+	assert.Equal(t, "orders", message.Topic, "the due partition is not blocked by the retried one")
+	assert.True(t, time.Now().Before(retryAt))
+
+	for offset := int64(0); offset < 2; offset++ {
+		message = <-handled
+		assert.Equal(t, "orders.retry.5s", message.Topic)
+		assert.Equal(t, offset, message.Offset, "the held partition keeps its order")
+		assert.False(t, time.Now().Before(retryAt.Truncate(time.Millisecond)))
+	}
+
+	pauser.mutex.Lock()
+	defer pauser.mutex.Unlock()
+	assert.Equal(t, []map[string][]int32{{"orders.retry.5s": {0}}}, pauser.paused)
+	assert.Equal(t, []map[string][]int32{{"orders.retry.5s": {0}}}, pauser.resumed)
+}
@@ -0,0 +1,73 @@
+package kafka
+
+import (
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/consumer/raw"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/consumer/redpanda"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/producer"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+)
+
+// Implementation selects the consumer implementation created by NewConsumer.
+type Implementation string
+
+const (
+	// ImplementationRaw selects raw.Consumer.
+	ImplementationRaw Implementation = "raw"
+	// ImplementationRedpanda selects redpanda.Consumer.
+	ImplementationRedpanda Implementation = "redpanda"
+)
+
+// ConsumerConfig holds the parameters of NewConsumer.
+type ConsumerConfig struct {
+	// Implementation defaults to ImplementationRedpanda.
+	Implementation Implementation
+	Brokers        []string
+	// Topics are regular expressions matched against the topics of the cluster.
+	Topics     []string
+	GroupID    string
+	InstanceID string
+	// InitialOffset is used if the group has no committed offset, it defaults to sarama.OffsetOldest.
+	InitialOffset int64
+	Options       []shared.Option
+}
+
+// NewConsumer creates the consumer implementation selected by config.
+func NewConsumer(config ConsumerConfig) (Consumer, error) {
+	initialOffset := config.InitialOffset
+	if initialOffset == 0 {
+		initialOffset = sarama.OffsetOldest
+	}
+
+	switch config.Implementation {
+	case ImplementationRaw:
+		options := append([]shared.Option{
+			shared.WithSaramaConfig(func(c *sarama.Config) {
+				c.Consumer.Offsets.Initial = initialOffset
+			}),
+		}, config.Options...)
+		c, err := raw.NewConsumer(config.Brokers, config.Topics, config.GroupID, config.InstanceID, options...)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	case ImplementationRedpanda, "":
+		c, err := redpanda.NewConsumer(config.Brokers, config.Topics, config.GroupID, config.InstanceID, initialOffset, config.Options...)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	default:
+		return nil, fmt.Errorf("unknown consumer implementation %q", config.Implementation)
+	}
+}
+
+// NewProducer creates a producer.Producer.
+func NewProducer(brokers []string, opts ...shared.Option) (Producer, error) {
+	p, err := producer.NewProducer(brokers, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,33 @@
+package kafka
+
+import (
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"regexp/syntax"
+	"testing"
+)
+
+func TestNewConsumerRejectsUnknownImplementation(t *testing.T) {
+	c, err := NewConsumer(ConsumerConfig{Implementation: "unknown"})
+	assert.Error(t, err)
+	assert.True(t, c == nil)
+}
+
+func TestNewConsumerReturnsNilInterfaceOnError(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()),
+	})
+
+	c, err := NewConsumer(ConsumerConfig{
+		Implementation: ImplementationRaw,
+		Brokers:        []string{broker.Addr()},
+		GroupID:        "group",
+		Topics:         []string{"("},
+	})
+	var syntaxErr *syntax.Error
+	assert.ErrorAs(t, err, &syntaxErr, "the invalid pattern is rejected although the broker is reachable")
+	assert.True(t, c == nil)
+}
@@ -0,0 +1,140 @@
+// Package health serves the state of producers and consumers as Kubernetes liveness and readiness probes.
+//
+// Register the clients of a service with a Handler and serve it:
+//
+//	checks := health.NewHandler(health.WithMaxErrorRate(0.5))
+//	checks.Register("consumer", consumer)
+//	checks.Register("producer", producer)
+//	http.Handle("/", checks)
+//
+// GET /healthz answers 200 while all clients are healthy and /readyz while all are also ready, both answer 503 otherwise.
+// The body is a JSON Report.
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/consumer/raw"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/consumer/redpanda"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/producer"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Source reports the health of a client, it is implemented by raw.Consumer, redpanda.Consumer and producer.Producer.
+type Source interface {
+	Health() shared.Health
+}
+
+var (
+	_ Source = (*raw.Consumer)(nil)
+	_ Source = (*redpanda.Consumer)(nil)
+	_ Source = (*producer.Producer)(nil)
+)
+
+// Report is the result of a check.
+type Report struct {
+	Healthy bool `json:"healthy"`
+	Ready   bool `json:"ready"`
+	// Components holds the health of every registered source by name.
+	Components map[string]shared.Health `json:"components"`
+}
+
+// Handler checks the registered sources and serves /healthz and /readyz.
+type Handler struct {
+	mutex   sync.RWMutex
+	sources map[string]Source
+	// maxErrorRate makes sources with a higher error rate unready, it is disabled if 0.
+	maxErrorRate float64
+	// minConnectedBrokers makes sources with fewer connected brokers unready.
+	minConnectedBrokers int
+}
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithMaxErrorRate makes producers unready while more than rate of their messages fail, rate is between 0 and 1.
+func WithMaxErrorRate(rate float64) Option {
+	return func(h *Handler) {
+		h.maxErrorRate = rate
+	}
+}
+
+// WithMinConnectedBrokers makes clients unready while fewer than brokers brokers are connected.
+// sarama connects lazily, so idle producers might not be connected to any broker.
+func WithMinConnectedBrokers(brokers int) Option {
+	return func(h *Handler) {
+		h.minConnectedBrokers = brokers
+	}
+}
+
+// NewHandler returns a Handler without sources.
+func NewHandler(opts ...Option) *Handler {
+	h := &Handler{sources: make(map[string]Source)}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Register adds source under name, replacing any source registered under the same name.
+func (h *Handler) Register(name string, source Source) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.sources[name] = source
+}
+
+// Unregister removes the source registered under name.
+func (h *Handler) Unregister(name string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.sources, name)
+}
+
+// Check collects the health of all sources, applying the thresholds of the Handler.
+func (h *Handler) Check() Report {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	report := Report{Healthy: true, Ready: true, Components: make(map[string]shared.Health, len(h.sources))}
+	for name, source := range h.sources {
+		health := source.Health()
+		if health.Ready && h.maxErrorRate > 0 && health.ErrorRate > h.maxErrorRate {
+			health.Ready = false
+			health.Reason = fmt.Sprintf("error rate %.2f above %.2f", health.ErrorRate, h.maxErrorRate)
+		}
+		if health.Ready && health.ConnectedBrokers < h.minConnectedBrokers {
+			health.Ready = false
+			health.Reason = fmt.Sprintf("%d of %d required brokers connected", health.ConnectedBrokers, h.minConnectedBrokers)
+		}
+		report.Healthy = report.Healthy && health.Healthy
+		report.Ready = report.Ready && health.Healthy && health.Ready
+		report.Components[name] = health
+	}
+	return report
+}
+
+// ServeHTTP answers requests to paths ending in /healthz and /readyz, so the Handler can be mounted under any prefix.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/healthz"):
+		report := h.Check()
+		h.write(w, report, report.Healthy)
+	case strings.HasSuffix(r.URL.Path, "/readyz"):
+		report := h.Check()
+		h.write(w, report, report.Ready)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) write(w http.ResponseWriter, report Report, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
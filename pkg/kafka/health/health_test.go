@@ -0,0 +1,51 @@
+package health
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type staticSource shared.Health
+
+func (s staticSource) Health() shared.Health {
+	return shared.Health(s)
+}
+
+func TestHandler(t *testing.T) {
+	checks := NewHandler(WithMaxErrorRate(0.5))
+	checks.Register("consumer", staticSource{Healthy: true, Ready: true})
+	checks.Register("producer", staticSource{Healthy: true, Ready: true, ErrorRate: 0.1})
+
+	get := func(path string) (int, Report) {
+		recorder := httptest.NewRecorder()
+		checks.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		var report Report
+		_ = json.Unmarshal(recorder.Body.Bytes(), &report)
+		return recorder.Code, report
+	}
+
+	code, _ := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = get("/probes/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	checks.Register("producer", staticSource{Healthy: true, Ready: true, ErrorRate: 0.9})
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, code, "a failing producer is still alive")
+	code, report := get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, report.Components["producer"].Ready)
+	assert.Contains(t, report.Components["producer"].Reason, "error rate")
+	assert.True(t, report.Components["consumer"].Ready)
+
+	checks.Register("consumer", staticSource{Reason: "consumer closed"})
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	code, _ = get("/metrics")
+	assert.Equal(t, http.StatusNotFound, code)
+}
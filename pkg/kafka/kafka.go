@@ -0,0 +1,61 @@
+// Package kafka defines the interfaces shared by the consumer and producer implementations of this module,
+// so applications can choose an implementation via configuration and replace it with a mock in unit tests.
+package kafka
+
+import (
+	"context"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/consumer/raw"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/consumer/redpanda"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/producer"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+)
+
+// Consumer is implemented by raw.Consumer and redpanda.Consumer.
+type Consumer interface {
+	// Start begins consuming. Implementations that start in their constructor return nil.
+	// Calling Start on a running consumer is a no-op.
+	Start(ctx context.Context) error
+	// Close flushes pending marks, commits, leaves the consumer group and closes the client.
+	// It returns ctx.Err() if ctx is done before the shutdown finished.
+	Close(ctx context.Context) error
+	// Done returns a channel that is closed once the consumer has been closed completely.
+	Done() <-chan struct{}
+	// IsReady returns whether the consumer has joined the group and is consuming its claims.
+	IsReady() bool
+	// GetTopics returns the topics the consumer is currently subscribed to.
+	GetTopics() []string
+	// GetMessage blocks until a message is available and returns it.
+	// It returns ctx.Err() if ctx is done first, or an error once the consumer is closed.
+	GetMessage(ctx context.Context) (*shared.KafkaMessage, error)
+	// Run passes consumed messages to handler on a pool of workers and marks them if handler returns nil.
+	// It returns once ctx is done or the consumer is closed.
+	Run(ctx context.Context, handler shared.Handler, opts shared.RunOptions) error
+	// GetMessages returns the channel of consumed messages.
+	GetMessages() <-chan *shared.KafkaMessage
+	// MarkMessage marks a message as processed, its offset is committed in the background.
+	// Commits only advance up to the oldest consumed message that has not been marked.
+	MarkMessage(message *shared.KafkaMessage)
+	// MarkMessages marks a slice of messages as processed.
+	MarkMessages(messages []*shared.KafkaMessage)
+	// GetStats returns the number of marked and consumed messages.
+	GetStats() (uint64, uint64)
+}
+
+// Producer is implemented by producer.Producer.
+type Producer interface {
+	// SendMessage hands a message to the producer without waiting for the broker acknowledgement.
+	SendMessage(ctx context.Context, message *shared.KafkaMessage) error
+	// SendMessageSync sends a message and waits until the broker acknowledged it.
+	// It returns the partition and offset the message was written to.
+	SendMessageSync(ctx context.Context, message *shared.KafkaMessage) (int32, int64, error)
+	// GetProducedMessages returns the number of produced and errored messages.
+	GetProducedMessages() (uint64, uint64)
+	// Close flushes buffered messages and stops the producer.
+	Close() error
+}
+
+var (
+	_ Consumer = (*raw.Consumer)(nil)
+	_ Consumer = (*redpanda.Consumer)(nil)
+	_ Producer = (*producer.Producer)(nil)
+)
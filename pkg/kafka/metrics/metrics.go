@@ -0,0 +1,284 @@
+// Package metrics exports the telemetry of producers and consumers to Prometheus.
+//
+// Create a Recorder, pass it to the constructors with shared.WithMetrics and either register it with
+// an existing prometheus.Registerer or serve it with Handler:
+//
+//	recorder := metrics.NewRecorder("umh")
+//	consumer, err := raw.NewConsumer(brokers, topics, group, instance, shared.WithMetrics(recorder))
+//	http.Handle("/metrics", recorder.Handler())
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ shared.MetricsRecorder = (*Recorder)(nil)
+
+// saramaQuantiles are the quantiles exported for sarama histograms.
+var saramaQuantiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+// invalidNameCharacters matches the characters of sarama metric names that are not allowed in Prometheus names.
+var invalidNameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Recorder is a shared.MetricsRecorder and prometheus.Collector.
+// sarama's metrics are only known at runtime, so the Recorder is an unchecked collector and Describe sends nothing.
+type Recorder struct {
+	namespace string
+
+	produced        *prometheus.CounterVec
+	produceErrors   *prometheus.CounterVec
+	consumed        *prometheus.CounterVec
+	marked          *prometheus.CounterVec
+	markedOffset    *prometheus.GaugeVec
+	commits         *prometheus.CounterVec
+	committedOffset *prometheus.GaugeVec
+	commitDuration  prometheus.Histogram
+	lag             *prometheus.GaugeVec
+	rebalances      *prometheus.CounterVec
+
+	channelLength   *prometheus.Desc
+	channelCapacity *prometheus.Desc
+
+	mutex      sync.Mutex
+	nextID     int
+	channels   map[int]channel
+	registries map[int]registry
+}
+
+// channel is a message channel registered with RegisterChannel.
+type channel struct {
+	owner string
+	name  string
+	fill  func() (int, int)
+}
+
+// registry is a sarama metric registry registered with RegisterSaramaRegistry.
+type registry struct {
+	clientID string
+	registry gometrics.Registry
+}
+
+// NewRecorder returns a Recorder whose metric names are prefixed with namespace, which may be empty.
+func NewRecorder(namespace string) *Recorder {
+	partitionLabels := []string{"topic", "partition"}
+	return &Recorder{
+		namespace: namespace,
+		produced: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "produced_messages_total",
+			Help: "Number of messages acknowledged by the broker.",
+		}, partitionLabels),
+		produceErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "produce_errors_total",
+			Help: "Number of messages that could not be produced.",
+		}, partitionLabels),
+		consumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "consumed_messages_total",
+			Help: "Number of messages handed out by consumers.",
+		}, partitionLabels),
+		marked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "marked_messages_total",
+			Help: "Number of messages marked as processed.",
+		}, partitionLabels),
+		markedOffset: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "marked_offset",
+			Help: "Last marked offset, which is committed with the next commit.",
+		}, partitionLabels),
+		commits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "committed_offsets_total",
+			Help: "Number of commits that included the partition.",
+		}, partitionLabels),
+		committedOffset: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "committed_offset",
+			Help: "Last committed offset, i.e. the next offset to consume.",
+		}, partitionLabels),
+		commitDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "commit_duration_seconds",
+			Help:    "Time it took to commit offsets.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		}),
+		lag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "consumer_lag",
+			Help: "Number of messages between the last consumed message and the end of the partition.",
+		}, partitionLabels),
+		rebalances: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "kafka", Name: "rebalances_total",
+			Help: "Number of consumer group sessions started.",
+		}, []string{"group"}),
+		channelLength: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "kafka", "channel_messages"),
+			"Number of messages buffered in a channel.",
+			[]string{"owner", "channel"}, nil,
+		),
+		channelCapacity: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "kafka", "channel_capacity"),
+			"Capacity of a channel.",
+			[]string{"owner", "channel"}, nil,
+		),
+		channels:   make(map[int]channel),
+		registries: make(map[int]registry),
+	}
+}
+
+// Handler returns an HTTP handler serving the metrics of the Recorder in the Prometheus exposition format.
+func (r *Recorder) Handler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(r)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+func (r *Recorder) MessageProduced(topic string, partition int32) {
+	r.produced.WithLabelValues(topic, formatPartition(partition)).Inc()
+}
+
+func (r *Recorder) MessageProduceFailed(topic string, partition int32) {
+	r.produceErrors.WithLabelValues(topic, formatPartition(partition)).Inc()
+}
+
+func (r *Recorder) MessageConsumed(topic string, partition int32) {
+	r.consumed.WithLabelValues(topic, formatPartition(partition)).Inc()
+}
+
+func (r *Recorder) MessageMarked(topic string, partition int32) {
+	r.marked.WithLabelValues(topic, formatPartition(partition)).Inc()
+}
+
+func (r *Recorder) OffsetMarked(topic string, partition int32, offset int64) {
+	r.markedOffset.WithLabelValues(topic, formatPartition(partition)).Set(float64(offset))
+}
+
+func (r *Recorder) OffsetCommitted(topic string, partition int32, offset int64) {
+	p := formatPartition(partition)
+	r.commits.WithLabelValues(topic, p).Inc()
+	r.committedOffset.WithLabelValues(topic, p).Set(float64(offset))
+}
+
+func (r *Recorder) CommitDuration(duration time.Duration) {
+	r.commitDuration.Observe(duration.Seconds())
+}
+
+func (r *Recorder) ConsumerLag(topic string, partition int32, lag int64) {
+	if lag < 0 {
+		lag = 0
+	}
+	r.lag.WithLabelValues(topic, formatPartition(partition)).Set(float64(lag))
+}
+
+func (r *Recorder) Rebalance(group string) {
+	r.rebalances.WithLabelValues(group).Inc()
+}
+
+func (r *Recorder) RegisterChannel(owner, name string, fill func() (int, int)) func() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	id := r.nextID
+	r.nextID++
+	r.channels[id] = channel{owner: owner, name: name, fill: fill}
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		delete(r.channels, id)
+	}
+}
+
+func (r *Recorder) RegisterSaramaRegistry(clientID string, reg gometrics.Registry) func() {
+	if reg == nil {
+		return func() {}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	id := r.nextID
+	r.nextID++
+	r.registries[id] = registry{clientID: clientID, registry: reg}
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		delete(r.registries, id)
+	}
+}
+
+// Describe sends nothing, which makes the Recorder an unchecked collector.
+func (r *Recorder) Describe(chan<- *prometheus.Desc) {}
+
+// Collect sends the current value of all metrics.
+func (r *Recorder) Collect(ch chan<- prometheus.Metric) {
+	r.produced.Collect(ch)
+	r.produceErrors.Collect(ch)
+	r.consumed.Collect(ch)
+	r.marked.Collect(ch)
+	r.markedOffset.Collect(ch)
+	r.commits.Collect(ch)
+	r.committedOffset.Collect(ch)
+	r.commitDuration.Collect(ch)
+	r.lag.Collect(ch)
+	r.rebalances.Collect(ch)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, c := range r.channels {
+		length, capacity := c.fill()
+		ch <- prometheus.MustNewConstMetric(r.channelLength, prometheus.GaugeValue, float64(length), c.owner, c.name)
+		ch <- prometheus.MustNewConstMetric(r.channelCapacity, prometheus.GaugeValue, float64(capacity), c.owner, c.name)
+	}
+	for id, reg := range r.registries {
+		r.collectSarama(ch, strconv.Itoa(id), reg)
+	}
+}
+
+// collectSarama converts the metrics of a sarama registry.
+// The registration id is added as label, since several clients may share a client ID.
+func (r *Recorder) collectSarama(ch chan<- prometheus.Metric, id string, reg registry) {
+	labels := []string{"client_id", "registry"}
+	values := []string{reg.clientID, id}
+	newDesc := func(name, suffix string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(r.namespace, "sarama", invalidNameCharacters.ReplaceAllString(name, "_")+suffix),
+			"sarama metric "+name+".",
+			labels, nil,
+		)
+	}
+
+	reg.registry.Each(func(name string, metric interface{}) {
+		switch m := metric.(type) {
+		case gometrics.Counter:
+			ch <- prometheus.MustNewConstMetric(newDesc(name, ""), prometheus.GaugeValue, float64(m.Count()), values...)
+		case gometrics.Gauge:
+			ch <- prometheus.MustNewConstMetric(newDesc(name, ""), prometheus.GaugeValue, float64(m.Value()), values...)
+		case gometrics.GaugeFloat64:
+			ch <- prometheus.MustNewConstMetric(newDesc(name, ""), prometheus.GaugeValue, m.Value(), values...)
+		case gometrics.Meter:
+			snapshot := m.Snapshot()
+			ch <- prometheus.MustNewConstMetric(newDesc(name, "_total"), prometheus.CounterValue, float64(snapshot.Count()), values...)
+			ch <- prometheus.MustNewConstMetric(newDesc(name, "_rate1"), prometheus.GaugeValue, snapshot.Rate1(), values...)
+		case gometrics.Histogram:
+			snapshot := m.Snapshot()
+			ch <- prometheus.MustNewConstSummary(newDesc(name, ""), uint64(snapshot.Count()), float64(snapshot.Sum()),
+				quantiles(snapshot.Percentiles(saramaQuantiles)), values...)
+		case gometrics.Timer:
+			snapshot := m.Snapshot()
+			ch <- prometheus.MustNewConstSummary(newDesc(name, ""), uint64(snapshot.Count()), float64(snapshot.Sum()),
+				quantiles(snapshot.Percentiles(saramaQuantiles)), values...)
+		}
+	})
+}
+
+// quantiles maps saramaQuantiles to their values.
+func quantiles(values []float64) map[float64]float64 {
+	result := make(map[float64]float64, len(values))
+	for i, q := range saramaQuantiles {
+		result[q] = values[i]
+	}
+	return result
+}
+
+// formatPartition formats a partition as label value.
+func formatPartition(partition int32) string {
+	return strconv.FormatInt(int64(partition), 10)
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorderCollects(t *testing.T) {
+	recorder := NewRecorder("umh")
+	recorder.MessageProduced("topic", 0)
+	recorder.MessageConsumed("topic", 1)
+	recorder.MessageMarked("topic", 1)
+	recorder.OffsetMarked("topic", 1, 43)
+	recorder.OffsetCommitted("topic", 1, 42)
+	recorder.CommitDuration(5 * time.Millisecond)
+	recorder.ConsumerLag("topic", 1, 7)
+	recorder.Rebalance("group")
+	unregister := recorder.RegisterChannel("group", "incoming", func() (int, int) {
+		return 3, 10
+	})
+
+	saramaRegistry := gometrics.NewRegistry()
+	gometrics.GetOrRegisterMeter("incoming-byte-rate", saramaRegistry).Mark(128)
+	gometrics.GetOrRegisterHistogram("request-latency-in-ms", saramaRegistry, gometrics.NewUniformSample(10)).Update(4)
+	recorder.RegisterSaramaRegistry("client", saramaRegistry)
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(recorder)
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			switch {
+			case metric.GetCounter() != nil:
+				values[family.GetName()] = metric.GetCounter().GetValue()
+			case metric.GetGauge() != nil:
+				values[family.GetName()] = metric.GetGauge().GetValue()
+			case metric.GetSummary() != nil:
+				values[family.GetName()] = float64(metric.GetSummary().GetSampleCount())
+			case metric.GetHistogram() != nil:
+				values[family.GetName()] = float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	assert.Equal(t, 1.0, values["umh_kafka_produced_messages_total"])
+	assert.Equal(t, 43.0, values["umh_kafka_marked_offset"])
+	assert.Equal(t, 42.0, values["umh_kafka_committed_offset"])
+	assert.Equal(t, 7.0, values["umh_kafka_consumer_lag"])
+	assert.Equal(t, 1.0, values["umh_kafka_rebalances_total"])
+	assert.Equal(t, 1.0, values["umh_kafka_commit_duration_seconds"])
+	assert.Equal(t, 3.0, values["umh_kafka_channel_messages"])
+	assert.Equal(t, 10.0, values["umh_kafka_channel_capacity"])
+	assert.Equal(t, 128.0, values["umh_sarama_incoming_byte_rate_total"])
+	assert.Equal(t, 1.0, values["umh_sarama_request_latency_in_ms"])
+
+	unregister()
+	server := httptest.NewServer(recorder.Handler())
+	defer server.Close()
+	response, err := server.Client().Get(server.URL)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(body), "umh_kafka_consumed_messages_total"))
+	assert.False(t, strings.Contains(string(body), "umh_kafka_channel_messages"))
+}
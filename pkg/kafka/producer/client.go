@@ -1,77 +1,525 @@
 package producer
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/IBM/sarama"
 	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// The errors are defined in shared, so consumers can check them without depending on this package.
+var (
+	// ErrInvalidMessage is reported if a message is nil or cannot be converted to a sarama.ProducerMessage.
+	ErrInvalidMessage = shared.ErrInvalidMessage
+	// ErrProducerClosed is returned when sending on a producer that has been closed.
+	ErrProducerClosed = shared.ErrProducerClosed
+	// ErrQueueFull is returned when the number of unacknowledged messages reached the in-flight limit.
+	ErrQueueFull = shared.ErrQueueFull
+	// ErrNotTransactional is returned by the transaction methods if the producer was created without shared.WithTransactionalID.
+	ErrNotTransactional = shared.ErrNotTransactional
+)
+
+var _ shared.TransactionalProducer = (*Producer)(nil)
+
 // Producer struct wraps a sarama.AsyncProducer and handles Kafka message production.
 type Producer struct {
 	producer         *sarama.AsyncProducer
+	client           sarama.Client
 	brokers          []string
 	producedMessages atomic.Uint64
 	erroredMessages  atomic.Uint64
 	running          atomic.Bool
+	// handlers tracks the success and error handler goroutines.
+	handlers sync.WaitGroup
+	// closeErrors collects the errors reported while the producer is shutting down.
+	closeErrors      sarama.ProducerErrors
+	closeErrorsMutex sync.Mutex
+	// inFlight holds one token per message that has been sent but not yet acknowledged.
+	inFlight chan struct{}
+	// closing is closed when Close is called, to release senders blocked on the input channel.
+	closing   chan struct{}
+	closeOnce sync.Once
+	// sendMutex guards closed, senders hold the read lock while handing messages to sarama.
+	sendMutex sync.RWMutex
+	closed    bool
+	// txnMutex serializes SendMessagesWithOffsets calls.
+	txnMutex sync.Mutex
+	// metrics receives produced and errored messages, unregisterMetrics removes sarama's registry from it.
+	metrics           shared.MetricsRecorder
+	unregisterMetrics func()
+	// errorRate tracks the share of failed messages for Health.
+	errorRate *shared.ErrorRate
+	// options converts messages and creates their publish spans.
+	options *shared.Config
+	// spool holds messages SendMessage could not hand to sarama, it is nil if the spool is disabled.
+	spool *spool
+	// unreachable is set when a message failed with a retriable error and cleared when one is acknowledged.
+	unreachable atomic.Bool
+	// sequence numbers the messages of SendMessage while the spool is enabled.
+	sequence atomic.Uint64
+	// replayDone is closed once the replay goroutine stopped.
+	replayDone chan struct{}
+	log        *zap.SugaredLogger
+}
+
+// errorRateWindow is the window Health reports the error rate for.
+const errorRateWindow = time.Minute
+
+// DeliveryReport describes the outcome of producing a single message.
+type DeliveryReport = shared.DeliveryReport
+
+// DeliveryCallback is invoked exactly once per message, after the broker acknowledged or rejected it.
+// It is called from the producer's handler goroutines and must not block.
+type DeliveryCallback = shared.DeliveryCallback
+
+// delivery is attached to every sarama.ProducerMessage as Metadata.
+type delivery struct {
+	callback DeliveryCallback
+	// span is the publish span, it is nil for replayed messages.
+	span trace.Span
+	// spool is set for messages of SendMessage, which are spooled if they fail with a retriable error.
+	spool bool
+	// sequence is the order in which SendMessage was called for the message, spooled messages are replayed in this order.
+	sequence uint64
+}
+
+// report ends the publish span and invokes the callback, if any.
+func (d *delivery) report(report DeliveryReport) {
+	if d.span != nil {
+		shared.EndProducerSpan(d.span, report.Partition, report.Offset, report.Err)
+	}
+	if d.callback != nil {
+		d.callback(report)
+	}
 }
 
 // NewProducer creates a new Producer with the given Kafka brokers.
-func NewProducer(brokers []string) (*Producer, error) {
-	config := sarama.NewConfig()
-	config.Producer.Return.Successes = false
-	config.Producer.Return.Errors = true
+func NewProducer(brokers []string, opts ...shared.Option) (*Producer, error) {
+	defaults := sarama.NewConfig()
+	defaults.Producer.Return.Successes = true
+	defaults.Producer.Return.Errors = true
+
+	config, err := shared.NewConfig(shared.Config{Sarama: defaults}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// Delivery reports and the in-flight accounting depend on both channels.
+	config.Sarama.Producer.Return.Successes = true
+	config.Sarama.Producer.Return.Errors = true
+	if config.Spool != nil && config.Sarama.Producer.Transaction.ID != "" {
+		return nil, errors.New("the spool cannot be used by transactional producers")
+	}
+	log := config.Logger.Sugar().With("client_id", config.Sarama.ClientID)
+	var s *spool
+	if config.Spool != nil {
+		if s, err = openSpool(*config.Spool, log); err != nil {
+			return nil, err
+		}
+	}
 
-	producer, err := sarama.NewAsyncProducer(brokers, config)
+	client, err := sarama.NewClient(brokers, config.Sarama)
 	if err != nil {
+		closeSpool(s)
+		return nil, err
+	}
+	producer, err := sarama.NewAsyncProducerFromClient(client)
+	if err != nil {
+		closeSpool(s)
+		_ = client.Close()
 		return nil, err
 	}
 
+	return newProducer(brokers, client, producer, config, s), nil
+}
+
+// newProducer starts the handler goroutines of producer and, if s is not nil, the replay goroutine.
+func newProducer(brokers []string, client sarama.Client, producer sarama.AsyncProducer, config *shared.Config, s *spool) *Producer {
 	p := &Producer{
-		brokers:  brokers,
-		producer: &producer,
+		brokers:   brokers,
+		producer:  &producer,
+		client:    client,
+		errorRate: shared.NewErrorRate(errorRateWindow),
+		log:       config.Logger.Sugar().With("client_id", config.Sarama.ClientID),
+		inFlight:  make(chan struct{}, config.MaxInFlight),
+		closing:   make(chan struct{}),
+		metrics:   config.Metrics,
+		options:   config,
+		spool:     s,
 	}
+	p.unregisterMetrics = config.Metrics.RegisterSaramaRegistry(config.Sarama.ClientID, config.Sarama.MetricRegistry)
 	p.running.Store(true)
+	p.handlers.Add(2)
+	go p.handleSuccesses()
 	go p.handleErrors()
+	if s != nil {
+		p.replayDone = make(chan struct{})
+		go p.replay(config.Spool.ReplayInterval)
+	}
+	return p
+}
 
-	return p, nil
+// closeSpool closes s if the producer could not be created.
+func closeSpool(s *spool) {
+	if s != nil {
+		_ = s.close()
+	}
+}
+
+// handleSuccesses handles acknowledged messages from the producer in a goroutine.
+// It returns once the producer has been closed and the successes channel is drained.
+func (p *Producer) handleSuccesses() {
+	defer p.handlers.Done()
+	for msg := range (*p.producer).Successes() {
+		<-p.inFlight
+		p.unreachable.Store(false)
+		p.producedMessages.Add(1)
+		p.errorRate.Record(false)
+		p.metrics.MessageProduced(msg.Topic, msg.Partition)
+		if d, ok := msg.Metadata.(*delivery); ok {
+			d.report(DeliveryReport{
+				Partition: msg.Partition,
+				Offset:    msg.Offset,
+			})
+		}
+	}
 }
 
 // handleErrors handles errors from the producer in a goroutine.
+// It returns once the producer has been closed and the errors channel is drained.
 func (p *Producer) handleErrors() {
-	timeout := time.NewTimer(shared.CycleTime)
-	defer timeout.Stop()
-
-	for p.running.Load() {
-		select {
-		case <-timeout.C:
-			timeout.Reset(shared.CycleTime)
-		case err := <-(*p.producer).Errors():
-			if err != nil {
-				p.erroredMessages.Add(1)
-				zap.S().Debugf("Error while producing message: %s", err.Error())
-			}
+	defer p.handlers.Done()
+	for err := range (*p.producer).Errors() {
+		if err == nil {
+			continue
+		}
+		<-p.inFlight
+		if p.respool(err) {
+			continue
+		}
+		p.erroredMessages.Add(1)
+		p.errorRate.Record(true)
+		p.log.Debugf("Error while producing message: %s", err.Error())
+		if !p.running.Load() {
+			p.closeErrorsMutex.Lock()
+			p.closeErrors = append(p.closeErrors, err)
+			p.closeErrorsMutex.Unlock()
+		}
+		if err.Msg == nil {
+			continue
+		}
+		p.metrics.MessageProduceFailed(err.Msg.Topic, err.Msg.Partition)
+		if d, ok := err.Msg.Metadata.(*delivery); ok {
+			d.report(DeliveryReport{
+				Partition: err.Msg.Partition,
+				Offset:    err.Msg.Offset,
+				Err:       err.Err,
+			})
 		}
 	}
 }
 
 // SendMessage sends a KafkaMessage to the producer.
-func (p *Producer) SendMessage(message *shared.KafkaMessage) {
+// It does not wait for the broker to acknowledge the message, use SendMessageSync or SendMessageAsync for that.
+// It returns ErrProducerClosed after Close, ErrQueueFull if the in-flight limit is reached,
+// or ctx.Err() if ctx is done before sarama accepted the message.
+// With shared.WithSpool, the message is written to the spool instead while the brokers are unreachable,
+// the in-flight limit is reached, or older messages are still spooled.
+func (p *Producer) SendMessage(ctx context.Context, message *shared.KafkaMessage) error {
+	if p.spool == nil {
+		return p.send(ctx, message, nil)
+	}
+	// The read lock is held until the message is spooled or enqueued, so nothing is spooled after Close.
+	p.sendMutex.RLock()
+	defer p.sendMutex.RUnlock()
+	if p.closed {
+		return ErrProducerClosed
+	}
+	msg, span, err := p.prepare(ctx, message, nil)
+	if err != nil {
+		return err
+	}
+	d := msg.Metadata.(*delivery)
+	d.spool = true
+	d.sequence = p.sequence.Add(1)
+	if p.unreachable.Load() || !p.spool.empty() {
+		return p.spoolMessage(msg, span)
+	}
+	err = p.enqueueLocked(ctx, msg)
+	if errors.Is(err, ErrQueueFull) {
+		return p.spoolMessage(msg, span)
+	}
+	if err != nil {
+		shared.EndProducerSpan(span, -1, -1, err)
+	}
+	return err
+}
+
+// SendMessageAsync sends a KafkaMessage to the producer and invokes callback once the broker acknowledged or rejected it.
+// If an error is returned, the message was not sent and callback is not invoked.
+func (p *Producer) SendMessageAsync(ctx context.Context, message *shared.KafkaMessage, callback DeliveryCallback) error {
+	return p.send(ctx, message, callback)
+}
+
+// send starts the publish span of message, propagates it in the message headers and enqueues the message.
+// The span ends once the broker acknowledged or rejected the message, or if it could not be enqueued.
+func (p *Producer) send(ctx context.Context, message *shared.KafkaMessage, callback DeliveryCallback) error {
+	msg, span, err := p.prepare(ctx, message, callback)
+	if err != nil {
+		return err
+	}
+	err = p.enqueue(ctx, msg)
+	if err != nil {
+		shared.EndProducerSpan(span, -1, -1, err)
+	}
+	return err
+}
+
+// prepare starts the publish span of message and converts it to a sarama.ProducerMessage carrying the span.
+func (p *Producer) prepare(ctx context.Context, message *shared.KafkaMessage, callback DeliveryCallback) (*sarama.ProducerMessage, trace.Span, error) {
 	if message == nil {
-		return
+		return nil, nil, ErrInvalidMessage
+	}
+	spanCtx, span := p.options.StartProducerSpan(ctx, message)
+	traced := *message
+	traced.Context = spanCtx
+	msg, err := p.options.ToProducerMessage(&traced)
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+		shared.EndProducerSpan(span, -1, -1, err)
+		return nil, nil, err
+	}
+	msg.Metadata = &delivery{callback: callback, span: span}
+	return msg, span, nil
+}
+
+// SendMessageSync sends a KafkaMessage to the producer and waits until the broker acknowledged it.
+// It returns the partition and offset the message was written to.
+// If ctx is done before the acknowledgement arrives, ctx.Err() is returned, but the message might still be produced.
+func (p *Producer) SendMessageSync(ctx context.Context, message *shared.KafkaMessage) (int32, int64, error) {
+	reports := make(chan DeliveryReport, 1)
+	err := p.SendMessageAsync(ctx, message, func(report DeliveryReport) {
+		reports <- report
+	})
+	if err != nil {
+		return -1, -1, err
 	}
-	(*p.producer).Input() <- shared.ToProducerMessage(message)
-	p.producedMessages.Add(1)
+
+	select {
+	case report := <-reports:
+		return report.Partition, report.Offset, report.Err
+	case <-ctx.Done():
+		return -1, -1, ctx.Err()
+	}
+}
+
+// enqueue reserves an in-flight slot and hands msg to sarama without blocking past ctx or Close.
+func (p *Producer) enqueue(ctx context.Context, msg *sarama.ProducerMessage) error {
+	p.sendMutex.RLock()
+	defer p.sendMutex.RUnlock()
+	if p.closed {
+		return ErrProducerClosed
+	}
+	return p.enqueueLocked(ctx, msg)
 }
 
-// Close stops the producer and returns any errors during closure.
+// enqueueLocked is enqueue for callers that hold the read lock of sendMutex and checked that the producer is open.
+func (p *Producer) enqueueLocked(ctx context.Context, msg *sarama.ProducerMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case p.inFlight <- struct{}{}:
+	default:
+		return ErrQueueFull
+	}
+
+	select {
+	case (*p.producer).Input() <- msg:
+		return nil
+	case <-ctx.Done():
+		<-p.inFlight
+		return ctx.Err()
+	case <-p.closing:
+		<-p.inFlight
+		return ErrProducerClosed
+	}
+}
+
+// GetInFlightMessages returns the number of messages that have been sent but not yet acknowledged, and the limit.
+func (p *Producer) GetInFlightMessages() (int, int) {
+	return len(p.inFlight), cap(p.inFlight)
+}
+
+// Close stops the producer, waits for all buffered messages to be flushed and returns any errors during closure.
+// Calling Close more than once is a no-op.
 func (p *Producer) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closing)
+	})
+	p.sendMutex.Lock()
+	if p.closed {
+		p.sendMutex.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.sendMutex.Unlock()
+
 	p.running.Store(false)
-	return (*p.producer).Close()
+	(*p.producer).AsyncClose()
+	p.handlers.Wait()
+	if p.spool != nil {
+		<-p.replayDone
+		if err := p.spool.close(); err != nil {
+			p.log.Warnf("Failed to close spool: %s", err)
+		}
+	}
+	p.unregisterMetrics()
+	// Producers created from a client do not close it.
+	if err := p.client.Close(); err != nil && !errors.Is(err, sarama.ErrClosedClient) {
+		p.log.Warnf("Failed to close producer client: %s", err)
+	}
+
+	p.closeErrorsMutex.Lock()
+	defer p.closeErrorsMutex.Unlock()
+	if len(p.closeErrors) > 0 {
+		return p.closeErrors
+	}
+	return nil
+}
+
+// IsTransactional returns whether the producer was created with shared.WithTransactionalID.
+func (p *Producer) IsTransactional() bool {
+	return (*p.producer).IsTransactional()
+}
+
+// BeginTransaction starts a transaction, all messages sent until CommitTransaction or AbortTransaction belong to it.
+func (p *Producer) BeginTransaction() error {
+	if err := p.checkTransactional(); err != nil {
+		return err
+	}
+	return (*p.producer).BeginTxn()
+}
+
+// CommitTransaction flushes the messages of the current transaction and commits it together with the added offsets.
+// If the transaction could not be committed, it has to be aborted with AbortTransaction.
+func (p *Producer) CommitTransaction() error {
+	if err := p.checkTransactional(); err != nil {
+		return err
+	}
+	return (*p.producer).CommitTxn()
+}
+
+// AbortTransaction aborts the current transaction, its messages are never visible to read_committed consumers.
+func (p *Producer) AbortTransaction() error {
+	if err := p.checkTransactional(); err != nil {
+		return err
+	}
+	return (*p.producer).AbortTxn()
+}
+
+// AddOffsetsToTransaction adds the offsets after the consumed messages to the current transaction,
+// so they are committed for the consumer group groupID if and only if the transaction commits.
+func (p *Producer) AddOffsetsToTransaction(groupID string, consumed []*shared.KafkaMessage) error {
+	if err := p.checkTransactional(); err != nil {
+		return err
+	}
+	offsets := make(map[string][]*sarama.PartitionOffsetMetadata)
+	for _, message := range consumed {
+		if message == nil {
+			continue
+		}
+		var found bool
+		for _, partition := range offsets[message.Topic] {
+			if partition.Partition == message.Partition {
+				found = true
+				if partition.Offset < message.Offset+1 {
+					partition.Offset = message.Offset + 1
+				}
+				break
+			}
+		}
+		if !found {
+			offsets[message.Topic] = append(offsets[message.Topic], &sarama.PartitionOffsetMetadata{
+				Partition: message.Partition,
+				Offset:    message.Offset + 1,
+			})
+		}
+	}
+	if len(offsets) == 0 {
+		return nil
+	}
+	return (*p.producer).AddOffsetsToTxn(offsets, groupID)
+}
+
+// SendMessagesWithOffsets atomically produces messages and commits the offsets after the consumed messages for groupID.
+// Either all messages become visible and the offsets are committed, or the transaction is aborted and an error is returned.
+// Messages sent concurrently with SendMessage become part of the transaction, so the producer should not be shared.
+func (p *Producer) SendMessagesWithOffsets(ctx context.Context, messages []*shared.KafkaMessage, groupID string, consumed []*shared.KafkaMessage) error {
+	p.txnMutex.Lock()
+	defer p.txnMutex.Unlock()
+
+	if err := p.BeginTransaction(); err != nil {
+		return err
+	}
+	err := p.sendInTransaction(ctx, messages, groupID, consumed)
+	if err == nil {
+		return nil
+	}
+	if abortErr := p.AbortTransaction(); abortErr != nil {
+		return errors.Join(err, abortErr)
+	}
+	return err
+}
+
+// sendInTransaction sends messages, adds the consumed offsets and commits the current transaction.
+func (p *Producer) sendInTransaction(ctx context.Context, messages []*shared.KafkaMessage, groupID string, consumed []*shared.KafkaMessage) error {
+	for _, message := range messages {
+		if err := p.SendMessage(ctx, message); err != nil {
+			return err
+		}
+	}
+	if err := p.AddOffsetsToTransaction(groupID, consumed); err != nil {
+		return err
+	}
+	return p.CommitTransaction()
+}
+
+// checkTransactional returns an error if the transaction methods cannot be used.
+func (p *Producer) checkTransactional() error {
+	p.sendMutex.RLock()
+	defer p.sendMutex.RUnlock()
+	if p.closed {
+		return ErrProducerClosed
+	}
+	if !(*p.producer).IsTransactional() {
+		return ErrNotTransactional
+	}
+	return nil
 }
 
 // GetProducedMessages returns the count of produced and errored messages.
 func (p *Producer) GetProducedMessages() (uint64, uint64) {
 	return p.producedMessages.Load(), p.erroredMessages.Load()
 }
+
+// Health returns the state of the producer for health and readiness probes.
+// The producer is healthy and ready until it is closed, ErrorRate reports failing sends.
+func (p *Producer) Health() shared.Health {
+	health := shared.Health{
+		Healthy:          p.running.Load(),
+		ConnectedBrokers: shared.ConnectedBrokers(p.client),
+		ErrorRate:        p.errorRate.Rate(),
+	}
+	health.Ready = health.Healthy
+	if !health.Healthy {
+		health.Reason = "producer closed"
+	}
+	return health
+}
@@ -1,8 +1,14 @@
 package producer
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"github.com/IBM/sarama"
 	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"math/rand"
 	"strconv"
 	"testing"
@@ -35,7 +41,10 @@ breakOuter:
 		case <-runtime.C:
 			break breakOuter
 		default:
-			testProducer.SendMessage(genMessage(t))
+			err = testProducer.SendMessage(context.Background(), genMessage(t))
+			if err != nil && !errors.Is(err, ErrQueueFull) {
+				t.Fatal(err)
+			}
 			produced := testProducer.producedMessages.Load()
 			if produced%100000 == 0 {
 				msgPerSec := float64(produced) / time.Since(now).Seconds()
@@ -60,6 +69,151 @@ breakOuter:
 	t.Logf("Errored messages: %d (%f%%)", errors, errorRate*100)
 }
 
+func newMockBroker(t *testing.T, produceResponse *sarama.MockProduceResponse) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("umh.v1.producer.test", 0, broker.BrokerID()),
+		"ProduceRequest": produceResponse,
+	})
+	return broker
+}
+
+func TestSendMessageSync(t *testing.T) {
+	broker := newMockBroker(t, sarama.NewMockProduceResponse(t))
+	defer broker.Close()
+
+	testProducer, err := NewProducer([]string{broker.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cncl := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cncl()
+	partition, _, err := testProducer.SendMessageSync(ctx, genMessage(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partition != 0 {
+		t.Fatalf("expected partition 0, got %d", partition)
+	}
+
+	if err = testProducer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	messages, errored := testProducer.GetProducedMessages()
+	if messages != 1 || errored != 0 {
+		t.Fatalf("expected 1 produced and 0 errored messages, got %d and %d", messages, errored)
+	}
+}
+
+func TestSendMessageAsyncReportsErrors(t *testing.T) {
+	broker := newMockBroker(t, sarama.NewMockProduceResponse(t).
+		SetError("umh.v1.producer.test", 0, sarama.ErrMessageSizeTooLarge))
+	defer broker.Close()
+
+	testProducer, err := NewProducer([]string{broker.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reports := make(chan DeliveryReport, 1)
+	err = testProducer.SendMessageAsync(context.Background(), genMessage(t), func(report DeliveryReport) {
+		reports <- report
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case report := <-reports:
+		if !errors.Is(report.Err, sarama.ErrMessageSizeTooLarge) {
+			t.Fatalf("expected %s, got %v", sarama.ErrMessageSizeTooLarge, report.Err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no delivery report received")
+	}
+
+	_ = testProducer.Close()
+	if _, errored := testProducer.GetProducedMessages(); errored != 1 {
+		t.Fatalf("expected 1 errored message, got %d", errored)
+	}
+}
+
+func TestSendMessageBackpressure(t *testing.T) {
+	broker := newMockBroker(t, sarama.NewMockProduceResponse(t))
+	defer broker.Close()
+	broker.SetLatency(500 * time.Millisecond)
+
+	testProducer, err := NewProducer([]string{broker.Addr()}, shared.WithMaxInFlight(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = testProducer.SendMessage(context.Background(), genMessage(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err = testProducer.SendMessage(context.Background(), genMessage(t)); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected %s, got %v", ErrQueueFull, err)
+	}
+
+	ctx, cncl := context.WithCancel(context.Background())
+	cncl()
+	if err = testProducer.SendMessage(ctx, genMessage(t)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %s, got %v", context.Canceled, err)
+	}
+
+	if err = testProducer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = testProducer.SendMessage(context.Background(), genMessage(t)); !errors.Is(err, ErrProducerClosed) {
+		t.Fatalf("expected %s, got %v", ErrProducerClosed, err)
+	}
+	if inFlight, _ := testProducer.GetInFlightMessages(); inFlight != 0 {
+		t.Fatalf("expected no in-flight messages after close, got %d", inFlight)
+	}
+}
+
+func TestSendMessageWithSASLPlain(t *testing.T) {
+	broker := newMockBroker(t, sarama.NewMockProduceResponse(t))
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"SaslHandshakeRequest":    sarama.NewMockSaslHandshakeResponse(t).SetEnabledMechanisms([]string{sarama.SASLTypePlaintext}),
+		"SaslAuthenticateRequest": sarama.NewMockSaslAuthenticateResponse(t),
+		"ApiVersionsRequest":      sarama.NewMockApiVersionsResponse(t),
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("umh.v1.producer.test", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+	})
+
+	testProducer, err := NewProducer([]string{broker.Addr()},
+		shared.WithKafkaVersion(sarama.V2_3_0_0),
+		shared.WithSASL(sarama.SASLTypePlaintext, "user", "password"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testProducer.Close()
+
+	ctx, cncl := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cncl()
+	if _, _, err = testProducer.SendMessageSync(ctx, genMessage(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	authenticated := false
+	for _, request := range broker.History() {
+		if _, ok := request.Request.(*sarama.SaslAuthenticateRequest); ok {
+			authenticated = true
+		}
+	}
+	if !authenticated {
+		t.Fatal("expected a SASL authenticate request")
+	}
+}
+
 func genMessage(t *testing.T) *shared.KafkaMessage {
 	msg := shared.KafkaMessage{
 		Topic: "umh.v1.producer.test",
@@ -90,3 +244,99 @@ func randSeq(n int) string {
 	}
 	return string(b)
 }
+
+func TestSendMessagesWithOffsets(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("umh.v1.producer.test", 0, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorTransaction, "txn", broker).
+			SetCoordinator(sarama.CoordinatorGroup, "group", broker),
+		"InitProducerIDRequest":     sarama.NewMockWrapper(&sarama.InitProducerIDResponse{ProducerID: 1}),
+		"AddPartitionsToTxnRequest": sarama.NewMockWrapper(&sarama.AddPartitionsToTxnResponse{Errors: map[string][]*sarama.PartitionError{"umh.v1.producer.test": {{Partition: 0}}}}),
+		"AddOffsetsToTxnRequest":    sarama.NewMockWrapper(&sarama.AddOffsetsToTxnResponse{}),
+		"TxnOffsetCommitRequest":    sarama.NewMockWrapper(&sarama.TxnOffsetCommitResponse{Topics: map[string][]*sarama.PartitionError{"umh.v1.input": {{Partition: 2}}}}),
+		"EndTxnRequest":             sarama.NewMockWrapper(&sarama.EndTxnResponse{}),
+		"ProduceRequest":            sarama.NewMockProduceResponse(t),
+	})
+
+	testProducer, err := NewProducer([]string{broker.Addr()},
+		shared.WithKafkaVersion(sarama.V2_3_0_0),
+		shared.WithTransactionalID("txn"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testProducer.Close()
+
+	consumed := []*shared.KafkaMessage{
+		{Topic: "umh.v1.input", Partition: 2, Offset: 7},
+		{Topic: "umh.v1.input", Partition: 2, Offset: 5},
+	}
+	ctx, cncl := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cncl()
+	if err = testProducer.SendMessagesWithOffsets(ctx, []*shared.KafkaMessage{genMessage(t)}, "group", consumed); err != nil {
+		t.Fatal(err)
+	}
+
+	var committed int64 = -1
+	for _, request := range broker.History() {
+		if commit, ok := request.Request.(*sarama.TxnOffsetCommitRequest); ok {
+			committed = commit.Topics["umh.v1.input"][0].Offset
+		}
+	}
+	if committed != 8 {
+		t.Fatalf("expected offset 8 to be committed, got %d", committed)
+	}
+}
+
+func TestTransactionsRequireTransactionalID(t *testing.T) {
+	broker := newMockBroker(t, sarama.NewMockProduceResponse(t))
+	defer broker.Close()
+
+	testProducer, err := NewProducer([]string{broker.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testProducer.Close()
+
+	if err = testProducer.BeginTransaction(); !errors.Is(err, ErrNotTransactional) {
+		t.Fatalf("expected %s, got %v", ErrNotTransactional, err)
+	}
+}
+
+func TestSendMessageCreatesPublishSpan(t *testing.T) {
+	broker := newMockBroker(t, sarama.NewMockProduceResponse(t))
+	defer broker.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	testProducer, err := NewProducer([]string{broker.Addr()},
+		shared.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cncl := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cncl()
+	message := genMessage(t)
+	if _, _, err = testProducer.SendMessageSync(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	if err = testProducer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Name() != "umh.v1.producer.test publish" || spans[0].SpanKind() != trace.SpanKindProducer {
+		t.Fatalf("unexpected span %s (%s)", spans[0].Name(), spans[0].SpanKind())
+	}
+	if message.Context != nil {
+		t.Fatal("expected the message passed to SendMessageSync to be left untouched")
+	}
+}
@@ -0,0 +1,620 @@
+package producer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSpoolFull is returned if a message does not fit into the spool and the eviction policy is shared.SpoolRejectNew.
+var ErrSpoolFull = errors.New("producer: spool full")
+
+// errCorruptRecord is returned when a spooled record is truncated or fails its checksum.
+var errCorruptRecord = errors.New("corrupt spool record")
+
+const (
+	// segmentSuffix is the file extension of spool segments, their names are the zero-padded segment IDs.
+	segmentSuffix = ".spool"
+	// recordHeaderSize is the size of the length and checksum preceding every record.
+	recordHeaderSize = 8
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// segment is a spool file holding records in the order they were appended.
+type segment struct {
+	id      uint64
+	path    string
+	size    int64
+	records int
+	// sequence is the highest send sequence appended by this producer, segments of a previous producer keep 0.
+	sequence uint64
+	// delivered marks the records acknowledged by a failed replay, they are skipped when the segment is replayed again.
+	// It is only kept in memory and only accessed by the replay goroutine.
+	delivered []bool
+}
+
+// spool is a write-ahead log of messages on disk, split into segment files.
+// Messages are appended to the newest segment and replayed from the oldest one, which is deleted once all
+// its messages have been acknowledged. Every record is stored as its little-endian length and CRC-32C checksum,
+// followed by the encoded message.
+type spool struct {
+	config shared.SpoolConfig
+	log    *zap.SugaredLogger
+
+	mutex    sync.Mutex
+	segments []*segment
+	// active is the file of the last segment, it is nil once the segment has been sealed.
+	active *os.File
+	// replaying is the segment handed out by next, it is neither appended to nor evicted.
+	replaying *segment
+	size      int64
+	records   int
+	evicted   uint64
+	// syncTimer syncs the active segment once SyncInterval passed after an append, it is nil while nothing is pending.
+	syncTimer *time.Timer
+	// closed is set by close, later appends fail instead of opening a new segment.
+	closed bool
+}
+
+// openSpool opens the segments left in config.Dir by a previous producer, or creates the directory.
+func openSpool(config shared.SpoolConfig, log *zap.SugaredLogger) (*spool, error) {
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{config: config, log: log}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg := &segment{id: id, path: filepath.Join(config.Dir, name)}
+		messages, size, err := readSegment(seg.path)
+		if err != nil {
+			log.Warnf("Spool segment %s is corrupt after %d messages: %s", seg.path, len(messages), err)
+		}
+		seg.size = size
+		seg.records = len(messages)
+		s.segments = append(s.segments, seg)
+		s.size += size
+		s.records += seg.records
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].id < s.segments[j].id
+	})
+	if s.records > 0 {
+		log.Infof("Found %d spooled messages in %s", s.records, config.Dir)
+	}
+	return s, nil
+}
+
+// append writes msg to the newest segment, evicting the oldest segments if the spool is full.
+// sequence is the order in which msg was sent, the messages of a segment are replayed in this order.
+// A message sent before messages of an older segment cannot be put back in order, this is logged.
+func (s *spool) append(msg *sarama.ProducerMessage, sequence uint64) error {
+	record, err := encodeRecord(msg, sequence)
+	if err != nil {
+		return err
+	}
+	size := int64(len(record))
+	if size > s.config.MaxSize {
+		return ErrSpoolFull
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrProducerClosed
+	}
+	if s.size+size > s.config.MaxSize {
+		if s.config.Eviction == shared.SpoolRejectNew {
+			return ErrSpoolFull
+		}
+		if err = s.evict(size); err != nil {
+			return err
+		}
+	}
+	if s.active == nil {
+		if err = s.startSegment(); err != nil {
+			return err
+		}
+	}
+	if _, err = s.active.Write(record); err != nil {
+		return err
+	}
+	seg := s.segments[len(s.segments)-1]
+	for _, older := range s.segments[:len(s.segments)-1] {
+		if older.sequence > sequence {
+			s.log.Warnf("Spooled a message after newer messages in segment %s, it is replayed out of order", older.path)
+			break
+		}
+	}
+	seg.size += size
+	seg.records++
+	if sequence > seg.sequence {
+		seg.sequence = sequence
+	}
+	s.size += size
+	s.records++
+	if seg.size >= s.config.SegmentSize {
+		return s.seal()
+	}
+	return s.scheduleSync()
+}
+
+// scheduleSync syncs the active segment now if SyncInterval is negative, or starts syncTimer.
+func (s *spool) scheduleSync() error {
+	if s.config.SyncInterval < 0 {
+		return s.active.Sync()
+	}
+	if s.syncTimer == nil {
+		s.syncTimer = time.AfterFunc(s.config.SyncInterval, s.sync)
+	}
+	return nil
+}
+
+// sync syncs the active segment, it is run by syncTimer.
+func (s *spool) sync() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.syncTimer = nil
+	if s.active == nil {
+		return
+	}
+	if err := s.active.Sync(); err != nil {
+		s.log.Warnf("Failed to sync spool segment: %s", err)
+	}
+}
+
+// evict deletes the oldest segments until size more bytes fit. The segment being replayed is kept.
+func (s *spool) evict(size int64) error {
+	for s.size+size > s.config.MaxSize {
+		index := 0
+		if s.replaying != nil && len(s.segments) > 0 && s.segments[0] == s.replaying {
+			index = 1
+		}
+		if index >= len(s.segments) {
+			return ErrSpoolFull
+		}
+		if index == len(s.segments)-1 && s.active != nil {
+			if err := s.seal(); err != nil {
+				return err
+			}
+		}
+		seg := s.segments[index]
+		if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		s.segments = append(s.segments[:index], s.segments[index+1:]...)
+		s.size -= seg.size
+		s.records -= seg.records
+		s.evicted += uint64(seg.records)
+		s.log.Warnf("Spool is full, evicted %d messages of segment %s", seg.records, seg.path)
+	}
+	return nil
+}
+
+// startSegment creates a new segment after the last one and makes it the active one.
+func (s *spool) startSegment() error {
+	var id uint64
+	if len(s.segments) > 0 {
+		id = s.segments[len(s.segments)-1].id + 1
+	}
+	path := filepath.Join(s.config.Dir, fmt.Sprintf("%020d%s", id, segmentSuffix))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	s.active = file
+	s.segments = append(s.segments, &segment{id: id, path: path})
+	return nil
+}
+
+// seal syncs and closes the active segment, the next append starts a new one.
+func (s *spool) seal() error {
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Sync()
+	if closeErr := s.active.Close(); err == nil {
+		err = closeErr
+	}
+	s.active = nil
+	return err
+}
+
+// next seals the oldest segment if it is still active and returns it with its messages, or nil if the spool is empty.
+// The segment must be passed to remove once its messages are acknowledged, or to release.
+func (s *spool) next() (*segment, []*sarama.ProducerMessage, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.replaying != nil {
+		return nil, nil, errors.New("spool segment is already being replayed")
+	}
+	if len(s.segments) == 0 {
+		return nil, nil, nil
+	}
+	seg := s.segments[0]
+	if len(s.segments) == 1 && s.active != nil {
+		if err := s.seal(); err != nil {
+			return nil, nil, err
+		}
+	}
+	messages, _, err := readSegment(seg.path)
+	if err != nil {
+		// The intact records before the corruption are still replayed.
+		s.log.Warnf("Spool segment %s is corrupt after %d messages: %s", seg.path, len(messages), err)
+	}
+	s.replaying = seg
+	return seg, messages, nil
+}
+
+// remove deletes a replayed segment.
+func (s *spool) remove(seg *segment) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.replaying = nil
+	for i, candidate := range s.segments {
+		if candidate != seg {
+			continue
+		}
+		s.segments = append(s.segments[:i], s.segments[i+1:]...)
+		s.size -= seg.size
+		s.records -= seg.records
+		return os.Remove(seg.path)
+	}
+	return nil
+}
+
+// release keeps a segment whose replay failed, so it is replayed again.
+func (s *spool) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.replaying = nil
+}
+
+// empty returns whether no messages are spooled.
+func (s *spool) empty() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.records == 0
+}
+
+// stats returns the number and size of spooled messages, and the number of evicted messages.
+func (s *spool) stats() (int, int64, uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.records, s.size, s.evicted
+}
+
+// close seals the active segment, spooled messages are replayed by the next producer using the same directory.
+func (s *spool) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	if s.syncTimer != nil {
+		s.syncTimer.Stop()
+		s.syncTimer = nil
+	}
+	return s.seal()
+}
+
+// readSegment returns the messages of the segment at path ordered by their send sequence, and its size.
+// If a record is truncated or fails its checksum, the messages before it are returned with errCorruptRecord.
+func readSegment(path string) ([]*sarama.ProducerMessage, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	var messages []*sarama.ProducerMessage
+	var sequences []uint64
+	for rest := data; len(rest) > 0; {
+		if len(rest) < recordHeaderSize {
+			err = errCorruptRecord
+			break
+		}
+		length := binary.LittleEndian.Uint32(rest)
+		checksum := binary.LittleEndian.Uint32(rest[4:])
+		rest = rest[recordHeaderSize:]
+		if uint64(length) > uint64(len(rest)) || crc32.Checksum(rest[:length], crcTable) != checksum {
+			err = errCorruptRecord
+			break
+		}
+		var msg *sarama.ProducerMessage
+		var sequence uint64
+		if msg, sequence, err = decodeMessage(rest[:length]); err != nil {
+			break
+		}
+		messages = append(messages, msg)
+		sequences = append(sequences, sequence)
+		rest = rest[length:]
+	}
+	// Messages that failed after being sent are appended after newer ones, replaying the segment restores the order.
+	sort.Stable(bySequence{messages: messages, sequences: sequences})
+	return messages, int64(len(data)), err
+}
+
+// bySequence sorts messages by their send sequence.
+type bySequence struct {
+	messages  []*sarama.ProducerMessage
+	sequences []uint64
+}
+
+func (b bySequence) Len() int           { return len(b.messages) }
+func (b bySequence) Less(i, j int) bool { return b.sequences[i] < b.sequences[j] }
+func (b bySequence) Swap(i, j int) {
+	b.messages[i], b.messages[j] = b.messages[j], b.messages[i]
+	b.sequences[i], b.sequences[j] = b.sequences[j], b.sequences[i]
+}
+
+// encodeRecord encodes msg with its record header. The message is stored as the length-prefixed topic, key and value,
+// the partition, the headers and the send sequence. A key length of 0 stands for a nil key, other key lengths are
+// incremented by one.
+func encodeRecord(msg *sarama.ProducerMessage, sequence uint64) ([]byte, error) {
+	record := make([]byte, recordHeaderSize, recordHeaderSize+64)
+	record = appendBytes(record, []byte(msg.Topic))
+	if msg.Key == nil {
+		record = binary.AppendUvarint(record, 0)
+	} else {
+		key, err := msg.Key.Encode()
+		if err != nil {
+			return nil, err
+		}
+		record = binary.AppendUvarint(record, uint64(len(key))+1)
+		record = append(record, key...)
+	}
+	var value []byte
+	if msg.Value != nil {
+		var err error
+		if value, err = msg.Value.Encode(); err != nil {
+			return nil, err
+		}
+	}
+	record = appendBytes(record, value)
+	record = binary.AppendVarint(record, int64(msg.Partition))
+	record = binary.AppendUvarint(record, uint64(len(msg.Headers)))
+	for _, header := range msg.Headers {
+		record = appendBytes(record, header.Key)
+		record = appendBytes(record, header.Value)
+	}
+	record = binary.AppendUvarint(record, sequence)
+	payload := record[recordHeaderSize:]
+	binary.LittleEndian.PutUint32(record, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:], crc32.Checksum(payload, crcTable))
+	return record, nil
+}
+
+func appendBytes(buffer []byte, b []byte) []byte {
+	buffer = binary.AppendUvarint(buffer, uint64(len(b)))
+	return append(buffer, b...)
+}
+
+// decodeMessage decodes a message encoded by encodeRecord, without its record header, and returns it with its sequence.
+func decodeMessage(payload []byte) (*sarama.ProducerMessage, uint64, error) {
+	d := decoder{buffer: payload}
+	msg := &sarama.ProducerMessage{Topic: string(d.bytes())}
+	if keyLength := d.uvarint(); keyLength > 0 {
+		msg.Key = sarama.ByteEncoder(d.next(keyLength - 1))
+	}
+	msg.Value = sarama.ByteEncoder(d.bytes())
+	msg.Partition = int32(d.varint())
+	headers := d.uvarint()
+	if headers > uint64(len(d.buffer)) {
+		return nil, 0, errCorruptRecord
+	}
+	for i := uint64(0); i < headers; i++ {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: d.bytes(), Value: d.bytes()})
+	}
+	sequence := d.uvarint()
+	if d.err != nil {
+		return nil, 0, d.err
+	}
+	return msg, sequence, nil
+}
+
+// decoder reads the fields of an encoded message, after the first error all reads return zero values.
+type decoder struct {
+	buffer []byte
+	err    error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buffer)
+	if n <= 0 {
+		d.err = errCorruptRecord
+		return 0
+	}
+	d.buffer = d.buffer[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buffer)
+	if n <= 0 {
+		d.err = errCorruptRecord
+		return 0
+	}
+	d.buffer = d.buffer[n:]
+	return v
+}
+
+func (d *decoder) next(length uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if length > uint64(len(d.buffer)) {
+		d.err = errCorruptRecord
+		return nil
+	}
+	b := d.buffer[:length:length]
+	d.buffer = d.buffer[length:]
+	return b
+}
+
+func (d *decoder) bytes() []byte {
+	return d.next(d.uvarint())
+}
+
+// spoolMessage writes msg to the spool and ends its publish span, it is replayed by the replay goroutine.
+func (p *Producer) spoolMessage(msg *sarama.ProducerMessage, span trace.Span) error {
+	if err := p.spool.append(msg, msg.Metadata.(*delivery).sequence); err != nil {
+		shared.EndProducerSpan(span, -1, -1, err)
+		return err
+	}
+	span.AddEvent("spooled")
+	span.End()
+	return nil
+}
+
+// respool writes a message of SendMessage that failed with a retriable error to the spool.
+// Its send sequence puts it back in front of newer messages of the same segment when the segment is replayed.
+// It returns false if the error has to be reported instead.
+func (p *Producer) respool(err *sarama.ProducerError) bool {
+	if err.Msg == nil || !shared.IsRetriable(err.Err) {
+		return false
+	}
+	d, ok := err.Msg.Metadata.(*delivery)
+	if !ok || !d.spool {
+		return false
+	}
+	p.unreachable.Store(true)
+	if spoolErr := p.spool.append(err.Msg, d.sequence); spoolErr != nil {
+		p.log.Warnf("Failed to spool message after %s: %s", err.Err, spoolErr)
+		return false
+	}
+	d.span.AddEvent("spooled", trace.WithAttributes(attribute.String("error", err.Err.Error())))
+	d.span.End()
+	return true
+}
+
+// replay replays spooled messages every interval until the producer is closed.
+func (p *Producer) replay(interval time.Duration) {
+	defer close(p.replayDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closing:
+			return
+		case <-ticker.C:
+		}
+		for p.replaySegment() {
+		}
+	}
+}
+
+// replaySegment sends the messages of the oldest segment in order and deletes it once all are acknowledged.
+// Sending stops at the first failure and the segment is kept, so it is replayed again before any newer segment.
+// Messages acknowledged before the failure are skipped then, only messages that were already in flight behind
+// the failed one can overtake it. It returns whether a segment was replayed.
+func (p *Producer) replaySegment() bool {
+	seg, messages, err := p.spool.next()
+	if err != nil {
+		p.log.Warnf("Failed to read spool: %s", err)
+		return false
+	}
+	if seg == nil {
+		return false
+	}
+	if len(seg.delivered) != len(messages) {
+		seg.delivered = make([]bool, len(messages))
+	}
+
+	type replayReport struct {
+		index int
+		err   error
+	}
+	reports := make(chan replayReport, len(messages))
+	var failed atomic.Bool
+	sent := 0
+	for i, msg := range messages {
+		if seg.delivered[i] {
+			continue
+		}
+		if failed.Load() {
+			break
+		}
+		index := i
+		msg.Metadata = &delivery{callback: func(report DeliveryReport) {
+			if report.Err != nil {
+				failed.Store(true)
+			}
+			reports <- replayReport{index: index, err: report.Err}
+		}}
+		if err = p.enqueueReplay(msg); err != nil {
+			break
+		}
+		sent++
+	}
+	for i := 0; i < sent; i++ {
+		report := <-reports
+		if report.err == nil {
+			seg.delivered[report.index] = true
+		} else if err == nil {
+			err = report.err
+		}
+	}
+	if err != nil {
+		p.spool.release()
+		p.log.Infof("Failed to replay spooled messages, retrying later: %s", err)
+		return false
+	}
+	if err = p.spool.remove(seg); err != nil {
+		p.log.Warnf("Failed to remove replayed spool segment %s: %s", seg.path, err)
+	}
+	p.log.Infof("Replayed %d spooled messages", sent)
+	return true
+}
+
+// enqueueReplay enqueues msg, waiting for a free in-flight slot until the producer is closed.
+func (p *Producer) enqueueReplay(msg *sarama.ProducerMessage) error {
+	for {
+		err := p.enqueue(context.Background(), msg)
+		if !errors.Is(err, ErrQueueFull) {
+			return err
+		}
+		select {
+		case <-p.closing:
+			return ErrProducerClosed
+		case <-time.After(shared.CycleTime):
+		}
+	}
+}
+
+// GetSpooledMessages returns the number and size in bytes of spooled messages, and the number of evicted messages.
+// It returns zeros if the spool is disabled.
+func (p *Producer) GetSpooledMessages() (int, int64, uint64) {
+	if p.spool == nil {
+		return 0, 0, 0
+	}
+	return p.spool.stats()
+}
@@ -0,0 +1,436 @@
+package producer
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2/pkg/kafka/shared"
+	"go.uber.org/zap"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func spoolMessage(i int) *sarama.ProducerMessage {
+	return &sarama.ProducerMessage{
+		Topic:   "umh.v1.producer.test",
+		Key:     sarama.StringEncoder(strconv.Itoa(i)),
+		Value:   sarama.ByteEncoder(make([]byte, 100)),
+		Headers: []sarama.RecordHeader{{Key: []byte("x-origin"), Value: []byte("edge")}},
+	}
+}
+
+func TestSpoolReplaysInOrderAcrossRestarts(t *testing.T) {
+	config := shared.SpoolConfig{Dir: t.TempDir(), SegmentSize: 512, MaxSize: 4096}
+	s, err := openSpool(config, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err = s.append(spoolMessage(i), uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = openSpool(config, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records, _, _ := s.stats(); records != 10 {
+		t.Fatalf("expected 10 spooled messages after reopening, got %d", records)
+	}
+	next := 0
+	for {
+		seg, messages, err := s.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seg == nil {
+			break
+		}
+		for _, msg := range messages {
+			key, _ := msg.Key.Encode()
+			if string(key) != strconv.Itoa(next) || string(msg.Headers[0].Value) != "edge" {
+				t.Fatalf("expected message %d, got key %s", next, key)
+			}
+			next++
+		}
+		if err = s.remove(seg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if next != 10 || !s.empty() {
+		t.Fatalf("expected all 10 messages to be replayed, got %d", next)
+	}
+}
+
+func TestSpoolEviction(t *testing.T) {
+	config := shared.SpoolConfig{Dir: t.TempDir(), SegmentSize: 512, MaxSize: 1024}
+	s, err := openSpool(config, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err = s.append(spoolMessage(i), uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, size, evicted := s.stats()
+	if size > config.MaxSize || evicted == 0 || records+int(evicted) != 20 {
+		t.Fatalf("expected the oldest segments to be evicted, got %d messages of %d bytes and %d evicted", records, size, evicted)
+	}
+	_, messages, err := s.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := messages[0].Key.Encode(); string(key) != strconv.Itoa(int(evicted)) {
+		t.Fatalf("expected the oldest kept message to be %d, got %s", evicted, key)
+	}
+
+	config = shared.SpoolConfig{Dir: t.TempDir(), SegmentSize: 512, MaxSize: 1024, Eviction: shared.SpoolRejectNew}
+	s, err = openSpool(config, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		err = s.append(spoolMessage(0), 0)
+	}
+	if err != ErrSpoolFull {
+		t.Fatalf("expected ErrSpoolFull, got %v", err)
+	}
+}
+
+func TestSpoolSkipsCorruptRecords(t *testing.T) {
+	config := shared.SpoolConfig{Dir: t.TempDir(), SegmentSize: 4096, MaxSize: 8192}
+	s, err := openSpool(config, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = s.append(spoolMessage(i), uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.close(); err != nil {
+		t.Fatal(err)
+	}
+	// Flip a byte in the value of the last record, as a crash or a bad disk would.
+	path := s.segments[0].path
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err = os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = openSpool(config, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, messages, err := s.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("expected the 2 intact messages, got %d", len(messages))
+	}
+}
+
+func TestSendMessageSpoolsWhileUnreachable(t *testing.T) {
+	broker := newMockBroker(t, sarama.NewMockProduceResponse(t).
+		SetError("umh.v1.producer.test", 0, sarama.ErrNotEnoughReplicas))
+	defer broker.Close()
+
+	testProducer, err := NewProducer([]string{broker.Addr()},
+		shared.WithSpool(shared.SpoolConfig{Dir: t.TempDir(), ReplayInterval: 50 * time.Millisecond}),
+		shared.WithSaramaConfig(func(config *sarama.Config) {
+			config.Producer.Retry.Max = 0
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cncl := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cncl()
+	for i := 0; i < 3; i++ {
+		if err = testProducer.SendMessage(ctx, genMessage(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool {
+		spooled, _, _ := testProducer.GetSpooledMessages()
+		return spooled > 0
+	})
+	if _, errored := testProducer.GetProducedMessages(); errored != 0 {
+		t.Fatalf("expected spooled messages not to be reported as errors, got %d", errored)
+	}
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("umh.v1.producer.test", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+	})
+	waitFor(t, func() bool {
+		produced, _ := testProducer.GetProducedMessages()
+		spooled, _, _ := testProducer.GetSpooledMessages()
+		return produced == 3 && spooled == 0
+	})
+	if err = testProducer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpoolKeepsRespooledMessagesInOrder(t *testing.T) {
+	s, err := openSpool(shared.SpoolConfig{Dir: t.TempDir(), SegmentSize: 4096, MaxSize: 8192}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Message 1 failed after being sent, while message 2 was already spooled.
+	for _, i := range []int{0, 2, 1, 3} {
+		if err = s.append(spoolMessage(i), uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, messages, err := s.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 4 {
+		t.Fatalf("expected 4 spooled messages, got %d", len(messages))
+	}
+	for i, msg := range messages {
+		if key, _ := msg.Key.Encode(); string(key) != strconv.Itoa(i) {
+			t.Fatalf("expected message %d to be replayed in position %d, got %s", i, i, key)
+		}
+	}
+}
+
+// flakyProducer acknowledges messages like sarama.AsyncProducer, but fails them from failAt until the test recovers it.
+// If hold is set, every message waits until it is closed.
+type flakyProducer struct {
+	sarama.AsyncProducer
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+
+	mutex     sync.Mutex
+	failAt    string
+	down      bool
+	hold      chan struct{}
+	sent      []string
+	delivered []string
+}
+
+func newFlakyProducer(failAt string) *flakyProducer {
+	f := &flakyProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage, 100),
+		errors:    make(chan *sarama.ProducerError, 100),
+		failAt:    failAt,
+	}
+	go func() {
+		defer close(f.successes)
+		defer close(f.errors)
+		for msg := range f.input {
+			f.mutex.Lock()
+			hold := f.hold
+			f.mutex.Unlock()
+			if hold != nil {
+				<-hold
+			}
+			key, _ := msg.Key.Encode()
+			f.mutex.Lock()
+			f.sent = append(f.sent, string(key))
+			if string(key) == f.failAt {
+				f.failAt = ""
+				f.down = true
+			}
+			down := f.down
+			if !down {
+				f.delivered = append(f.delivered, string(key))
+			}
+			f.mutex.Unlock()
+			if down {
+				f.errors <- &sarama.ProducerError{Msg: msg, Err: sarama.ErrNotEnoughReplicas}
+			} else {
+				f.successes <- msg
+			}
+		}
+	}()
+	return f
+}
+
+func (f *flakyProducer) Input() chan<- *sarama.ProducerMessage     { return f.input }
+func (f *flakyProducer) Successes() <-chan *sarama.ProducerMessage { return f.successes }
+func (f *flakyProducer) Errors() <-chan *sarama.ProducerError      { return f.errors }
+func (f *flakyProducer) AsyncClose()                               { close(f.input) }
+
+func TestReplayResumesAfterPartialFailure(t *testing.T) {
+	spoolConfig := shared.SpoolConfig{Dir: t.TempDir(), SegmentSize: 1 << 20, MaxSize: 1 << 20, ReplayInterval: 20 * time.Millisecond}
+	s, err := openSpool(spoolConfig, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err = s.append(spoolMessage(i), uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	broker := newMockBroker(t, sarama.NewMockProduceResponse(t))
+	defer broker.Close()
+	config, err := shared.NewConfig(shared.Config{Logger: zap.NewNop()}, shared.WithSpool(spoolConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := sarama.NewClient([]string{broker.Addr()}, config.Sarama)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky := newFlakyProducer("4")
+	testProducer := newProducer([]string{broker.Addr()}, client, flaky, config, s)
+
+	// Wait until the failed replay released the segment before the broker recovers.
+	waitFor(t, func() bool {
+		_, errored := testProducer.GetProducedMessages()
+		return errored > 0 && len(testProducer.inFlight) == 0
+	})
+	flaky.mutex.Lock()
+	flaky.down = false
+	flaky.mutex.Unlock()
+	waitFor(t, func() bool {
+		spooled, _, _ := testProducer.GetSpooledMessages()
+		return spooled == 0
+	})
+	if err = testProducer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	flaky.mutex.Lock()
+	defer flaky.mutex.Unlock()
+	if len(flaky.delivered) != 10 {
+		t.Fatalf("expected each message to be delivered once, got %v", flaky.delivered)
+	}
+	for i, key := range flaky.delivered {
+		if key != strconv.Itoa(i) {
+			t.Fatalf("expected the messages to be delivered in order, got %v", flaky.delivered)
+		}
+	}
+	for i, key := range flaky.sent[:4] {
+		if key != strconv.Itoa(i) {
+			t.Fatalf("expected the messages before the failure to be sent once, got %v", flaky.sent)
+		}
+	}
+	for _, key := range flaky.sent[4:] {
+		if n, _ := strconv.Atoi(key); n < 4 {
+			t.Fatalf("expected acknowledged messages not to be sent again, got %v", flaky.sent)
+		}
+	}
+}
+
+func TestSendMessageRespoolsFailedMessagesBeforeNewerOnes(t *testing.T) {
+	spoolConfig := shared.SpoolConfig{Dir: t.TempDir(), ReplayInterval: time.Hour}
+	broker := newMockBroker(t, sarama.NewMockProduceResponse(t))
+	defer broker.Close()
+	config, err := shared.NewConfig(shared.Config{Logger: zap.NewNop()}, shared.WithSpool(spoolConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := openSpool(*config.Spool, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := sarama.NewClient([]string{broker.Addr()}, config.Sarama)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky := newFlakyProducer("")
+	flaky.down = true
+	flaky.hold = make(chan struct{})
+	testProducer := newProducer([]string{broker.Addr()}, client, flaky, config, s)
+
+	ctx, cncl := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cncl()
+	send := func(key string) {
+		t.Helper()
+		message := &shared.KafkaMessage{Topic: "umh.v1.producer.test", Key: []byte(key), Value: []byte(key)}
+		if err := testProducer.SendMessage(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// "old" is in flight when the brokers become unreachable and "new" is spooled, then "old" fails.
+	send("old")
+	testProducer.unreachable.Store(true)
+	send("new")
+	close(flaky.hold)
+	waitFor(t, func() bool {
+		spooled, _, _ := testProducer.GetSpooledMessages()
+		return spooled == 2
+	})
+	if _, errored := testProducer.GetProducedMessages(); errored != 0 {
+		t.Fatalf("expected the failed message to be spooled instead of reported, got %d errors", errored)
+	}
+
+	flaky.mutex.Lock()
+	flaky.down = false
+	flaky.mutex.Unlock()
+	if !testProducer.replaySegment() {
+		t.Fatal("expected the spooled messages to be replayed")
+	}
+	if err = testProducer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	flaky.mutex.Lock()
+	defer flaky.mutex.Unlock()
+	if len(flaky.delivered) != 2 || flaky.delivered[0] != "old" || flaky.delivered[1] != "new" {
+		t.Fatalf("expected old to be delivered before new, got %v", flaky.delivered)
+	}
+}
+
+func TestSendMessageAfterCloseWithSpool(t *testing.T) {
+	broker := newMockBroker(t, sarama.NewMockProduceResponse(t))
+	defer broker.Close()
+
+	dir := t.TempDir()
+	testProducer, err := NewProducer([]string{broker.Addr()}, shared.WithSpool(shared.SpoolConfig{Dir: dir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Force the spool path, as if the brokers had become unreachable before Close.
+	testProducer.unreachable.Store(true)
+	if err = testProducer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = testProducer.SendMessage(context.Background(), genMessage(t)); err != ErrProducerClosed {
+		t.Fatalf("expected ErrProducerClosed, got %v", err)
+	}
+	if spooled, _, _ := testProducer.GetSpooledMessages(); spooled != 0 {
+		t.Fatalf("expected nothing to be spooled after Close, got %d messages", spooled)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no spool segment to be created after Close, got %d files", len(entries))
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(shared.CycleTime)
+	}
+}
@@ -0,0 +1,63 @@
+package shared
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff describes exponentially growing delays between retries.
+type Backoff struct {
+	// Initial is the delay before the first retry.
+	Initial time.Duration
+	// Max caps the delay.
+	Max time.Duration
+	// Multiplier is the factor the delay grows by with every attempt.
+	Multiplier float64
+	// Jitter randomizes every delay by up to this fraction in either direction, so clients do not retry in lockstep.
+	Jitter float64
+}
+
+// DefaultBackoff starts at one second and doubles up to thirty seconds, with 20% jitter.
+var DefaultBackoff = Backoff{
+	Initial:    CycleTime * 10,
+	Max:        30 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay returns the delay before retry number attempt, starting at 0.
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt))
+	if delay > float64(b.Max) || math.IsInf(delay, 0) || math.IsNaN(delay) {
+		delay = float64(b.Max)
+	}
+	delay += delay * b.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
+
+// validate returns an error if the backoff cannot produce sensible delays.
+func (b Backoff) validate() error {
+	if b.Initial <= 0 || b.Max < b.Initial {
+		return errors.New("backoff needs a positive initial delay not above the maximum delay")
+	}
+	if b.Multiplier < 1 {
+		return errors.New("backoff multiplier must be at least 1")
+	}
+	if b.Jitter < 0 || b.Jitter > 1 {
+		return errors.New("backoff jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// WithBackoff sets the delays between retries after retriable errors.
+func WithBackoff(backoff Backoff) Option {
+	return func(c *Config) error {
+		if err := backoff.validate(); err != nil {
+			return err
+		}
+		c.Backoff = backoff
+		return nil
+	}
+}
@@ -0,0 +1,22 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2, Jitter: 0.1}
+	for i := 0; i < 100; i++ {
+		assert.InDelta(t, time.Second, backoff.Delay(0), float64(100*time.Millisecond))
+		assert.InDelta(t, 4*time.Second, backoff.Delay(2), float64(400*time.Millisecond))
+		assert.InDelta(t, 10*time.Second, backoff.Delay(1000), float64(time.Second))
+	}
+
+	config, err := NewConfig(Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultBackoff, config.Backoff)
+	_, err = NewConfig(Config{}, WithBackoff(Backoff{Initial: time.Second, Max: time.Millisecond, Multiplier: 2}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,290 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"time"
+)
+
+// DefaultChannelBufferSize is the default capacity of the incoming and to-be-marked message channels.
+const DefaultChannelBufferSize = 100_000
+
+// DefaultMaxInFlight is the default limit of produced messages that have not been acknowledged yet.
+const DefaultMaxInFlight = 100_000
+
+// Config holds the settings shared by producers and consumers.
+type Config struct {
+	// Sarama is the underlying sarama configuration.
+	// The constructors pre-populate it with their defaults before any Option is applied.
+	Sarama *sarama.Config
+	// ChannelBufferSize is the capacity of the incoming and to-be-marked message channels.
+	ChannelBufferSize int
+	// CommitInterval is the interval in which marked offsets are committed.
+	CommitInterval time.Duration
+	// MaxInFlight limits the number of produced messages that have not been acknowledged yet.
+	MaxInFlight int
+	// SkipHeaderDecoding makes consumers deliver messages without Headers and Tracing.
+	SkipHeaderDecoding bool
+	// Metrics receives the telemetry of the producer or consumer, it defaults to NoopMetricsRecorder.
+	Metrics MetricsRecorder
+	// RebalanceListener is notified about assigned, revoked and lost partitions, it is nil if not configured.
+	RebalanceListener *RebalanceListener
+	// Backoff controls the delays between retries after retriable errors, it defaults to DefaultBackoff.
+	Backoff Backoff
+	// Logger receives the logs of the producer or consumer, it defaults to the global zap logger at construction time.
+	Logger *zap.Logger
+	// TracerProvider creates the producer and consumer spans, it defaults to the global OpenTelemetry provider.
+	TracerProvider trace.TracerProvider
+	// Propagator writes and reads span contexts in message headers, it defaults to W3C trace context.
+	Propagator propagation.TextMapPropagator
+	// Trace bounds and encodes the x-trace header of produced messages, it defaults to DefaultTraceOptions.
+	Trace TraceOptions
+	// Spool configures the disk spool of the producer, it is nil if the spool is disabled.
+	Spool *SpoolConfig
+}
+
+// Option configures a Config.
+type Option func(*Config) error
+
+// NewConfig applies opts on top of the given defaults and validates the result.
+func NewConfig(defaults Config, opts ...Option) (*Config, error) {
+	c := defaults
+	if c.Sarama == nil {
+		c.Sarama = sarama.NewConfig()
+	}
+	if c.ChannelBufferSize == 0 {
+		c.ChannelBufferSize = DefaultChannelBufferSize
+	}
+	if c.CommitInterval == 0 {
+		c.CommitInterval = c.Sarama.Consumer.Offsets.AutoCommit.Interval
+	}
+	if c.MaxInFlight == 0 {
+		c.MaxInFlight = DefaultMaxInFlight
+	}
+	if c.Metrics == nil {
+		c.Metrics = NoopMetricsRecorder{}
+	}
+	if c.Backoff == (Backoff{}) {
+		c.Backoff = DefaultBackoff
+	}
+	if c.Logger == nil {
+		c.Logger = zap.L()
+	}
+	if c.TracerProvider == nil {
+		c.TracerProvider = otel.GetTracerProvider()
+	}
+	if c.Propagator == nil {
+		c.Propagator = defaultPropagator
+	}
+	if c.Trace == (TraceOptions{}) {
+		c.Trace = DefaultTraceOptions
+	}
+
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if err := opt(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.ChannelBufferSize < 0 {
+		return nil, fmt.Errorf("invalid channel buffer size %d", c.ChannelBufferSize)
+	}
+	if c.CommitInterval <= 0 {
+		return nil, fmt.Errorf("invalid commit interval %s", c.CommitInterval)
+	}
+	if c.MaxInFlight <= 0 {
+		return nil, fmt.Errorf("invalid max in-flight %d", c.MaxInFlight)
+	}
+	if err := c.Sarama.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// WithClientID sets the client ID sent to the brokers with every request.
+func WithClientID(clientID string) Option {
+	return func(c *Config) error {
+		if clientID == "" {
+			return errors.New("client ID must not be empty")
+		}
+		c.Sarama.ClientID = clientID
+		return nil
+	}
+}
+
+// WithKafkaVersion sets the Kafka protocol version used to talk to the brokers.
+func WithKafkaVersion(version sarama.KafkaVersion) Option {
+	return func(c *Config) error {
+		c.Sarama.Version = version
+		return nil
+	}
+}
+
+// WithKafkaVersionString parses version (for example "3.6.0") and sets it as the Kafka protocol version.
+func WithKafkaVersionString(version string) Option {
+	return func(c *Config) error {
+		v, err := sarama.ParseKafkaVersion(version)
+		if err != nil {
+			return err
+		}
+		c.Sarama.Version = v
+		return nil
+	}
+}
+
+// WithChannelBufferSize sets the capacity of the incoming and to-be-marked message channels.
+func WithChannelBufferSize(size int) Option {
+	return func(c *Config) error {
+		c.ChannelBufferSize = size
+		return nil
+	}
+}
+
+// WithCommitInterval sets the interval in which marked offsets are committed.
+func WithCommitInterval(interval time.Duration) Option {
+	return func(c *Config) error {
+		c.CommitInterval = interval
+		c.Sarama.Consumer.Offsets.AutoCommit.Interval = interval
+		return nil
+	}
+}
+
+// WithInitialOffset sets where consumers start on partitions without a committed offset,
+// either sarama.OffsetOldest or sarama.OffsetNewest.
+func WithInitialOffset(offset int64) Option {
+	return func(c *Config) error {
+		if offset != sarama.OffsetOldest && offset != sarama.OffsetNewest {
+			return fmt.Errorf("invalid initial offset %d", offset)
+		}
+		c.Sarama.Consumer.Offsets.Initial = offset
+		return nil
+	}
+}
+
+// WithMetadataRefreshFrequency sets how often the cluster metadata is refreshed in the background.
+func WithMetadataRefreshFrequency(frequency time.Duration) Option {
+	return func(c *Config) error {
+		c.Sarama.Metadata.RefreshFrequency = frequency
+		return nil
+	}
+}
+
+// WithRebalanceStrategy sets the partition assignment strategy of the consumer group.
+// Use sarama.NewBalanceStrategyRange, sarama.NewBalanceStrategyRoundRobin or sarama.NewBalanceStrategySticky.
+func WithRebalanceStrategy(strategy sarama.BalanceStrategy) Option {
+	return func(c *Config) error {
+		if strategy == nil {
+			return errors.New("rebalance strategy must not be nil")
+		}
+		c.Sarama.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{strategy}
+		return nil
+	}
+}
+
+// WithFetchSizes sets the minimum, default and maximum number of bytes fetched per request.
+// A maximum of 0 means no limit.
+func WithFetchSizes(minBytes, defaultBytes, maxBytes int32) Option {
+	return func(c *Config) error {
+		c.Sarama.Consumer.Fetch.Min = minBytes
+		c.Sarama.Consumer.Fetch.Default = defaultBytes
+		c.Sarama.Consumer.Fetch.Max = maxBytes
+		return nil
+	}
+}
+
+// WithMaxInFlight limits the number of produced messages that have not been acknowledged yet.
+// Once the limit is reached, the producer returns an error instead of blocking.
+func WithMaxInFlight(maxInFlight int) Option {
+	return func(c *Config) error {
+		c.MaxInFlight = maxInFlight
+		return nil
+	}
+}
+
+// WithoutHeaderDecoding makes consumers skip decoding the record headers, see FromConsumerMessageWithoutHeaders.
+func WithoutHeaderDecoding() Option {
+	return func(c *Config) error {
+		c.SkipHeaderDecoding = true
+		return nil
+	}
+}
+
+// WithIdempotence enables the idempotent producer, so retries never write a message twice.
+// It requires Kafka 0.11 or newer and forces acks=all with a single in-flight request per broker.
+func WithIdempotence() Option {
+	return func(c *Config) error {
+		if !c.Sarama.Version.IsAtLeast(sarama.V0_11_0_0) {
+			c.Sarama.Version = sarama.V0_11_0_0
+		}
+		c.Sarama.Producer.Idempotent = true
+		c.Sarama.Producer.RequiredAcks = sarama.WaitForAll
+		c.Sarama.Net.MaxOpenRequests = 1
+		if c.Sarama.Producer.Retry.Max < 1 {
+			c.Sarama.Producer.Retry.Max = 1
+		}
+		return nil
+	}
+}
+
+// WithTransactionalID makes the producer transactional, see producer.Producer.BeginTransaction.
+// The ID must be unique per producer instance and stable across restarts, so the broker can fence zombie instances.
+// It implies WithIdempotence.
+func WithTransactionalID(transactionalID string) Option {
+	return func(c *Config) error {
+		if transactionalID == "" {
+			return errors.New("transactional ID must not be empty")
+		}
+		if err := WithIdempotence()(c); err != nil {
+			return err
+		}
+		c.Sarama.Producer.Transaction.ID = transactionalID
+		return nil
+	}
+}
+
+// WithReadCommitted makes consumers skip messages of aborted transactions and wait for open transactions to complete.
+func WithReadCommitted() Option {
+	return func(c *Config) error {
+		if !c.Sarama.Version.IsAtLeast(sarama.V0_11_0_0) {
+			c.Sarama.Version = sarama.V0_11_0_0
+		}
+		c.Sarama.Consumer.IsolationLevel = sarama.ReadCommitted
+		return nil
+	}
+}
+
+// FromConsumerMessage converts a sarama.ConsumerMessage to a KafkaMessage, honoring SkipHeaderDecoding.
+// With headers, it extracts the propagated span context and records a receive span, see KafkaMessage.Context.
+func (c *Config) FromConsumerMessage(message *sarama.ConsumerMessage) *KafkaMessage {
+	if c.SkipHeaderDecoding {
+		return FromConsumerMessageWithoutHeaders(message)
+	}
+	m := fromConsumerMessage(message, true, c.Propagator)
+	if m != nil {
+		c.startReceiveSpan(m)
+	}
+	return m
+}
+
+// ToProducerMessage converts a KafkaMessage to a sarama.ProducerMessage like the ToProducerMessage function,
+// propagating the span context with the configured propagator and bounding the x-trace header with the configured TraceOptions.
+// Unlike the function, it returns why a message cannot be converted.
+func (c *Config) ToProducerMessage(message *KafkaMessage) (*sarama.ProducerMessage, error) {
+	return toProducerMessage(message, c.Propagator, c.Trace)
+}
+
+// WithSaramaConfig allows modifying settings of the underlying sarama configuration that have no dedicated Option.
+func WithSaramaConfig(modify func(config *sarama.Config)) Option {
+	return func(c *Config) error {
+		modify(c.Sarama)
+		return nil
+	}
+}
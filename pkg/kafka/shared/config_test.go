@@ -0,0 +1,69 @@
+package shared
+
+import (
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNewConfigKeepsDefaults(t *testing.T) {
+	defaults := sarama.NewConfig()
+	defaults.Version = sarama.V2_3_0_0
+	defaults.Consumer.Offsets.Initial = sarama.OffsetOldest
+
+	c, err := NewConfig(Config{Sarama: defaults, CommitInterval: 10 * time.Second})
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.V2_3_0_0, c.Sarama.Version)
+	assert.Equal(t, sarama.OffsetOldest, c.Sarama.Consumer.Offsets.Initial)
+	assert.Equal(t, DefaultChannelBufferSize, c.ChannelBufferSize)
+	assert.Equal(t, DefaultMaxInFlight, c.MaxInFlight)
+	assert.Equal(t, 10*time.Second, c.CommitInterval)
+}
+
+func TestNewConfigAppliesOptions(t *testing.T) {
+	c, err := NewConfig(Config{},
+		WithClientID("umh-test"),
+		WithKafkaVersionString("3.5.0"),
+		WithChannelBufferSize(10),
+		WithCommitInterval(5*time.Second),
+		WithMetadataRefreshFrequency(time.Minute),
+		WithRebalanceStrategy(sarama.NewBalanceStrategySticky()),
+		WithFetchSizes(1, 1024, 4096),
+		WithMaxInFlight(42),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "umh-test", c.Sarama.ClientID)
+	assert.Equal(t, sarama.V3_5_0_0, c.Sarama.Version)
+	assert.Equal(t, 10, c.ChannelBufferSize)
+	assert.Equal(t, 5*time.Second, c.CommitInterval)
+	assert.Equal(t, 5*time.Second, c.Sarama.Consumer.Offsets.AutoCommit.Interval)
+	assert.Equal(t, time.Minute, c.Sarama.Metadata.RefreshFrequency)
+	assert.Equal(t, sarama.StickyBalanceStrategyName, c.Sarama.Consumer.Group.Rebalance.GroupStrategies[0].Name())
+	assert.Equal(t, int32(1024), c.Sarama.Consumer.Fetch.Default)
+	assert.Equal(t, 42, c.MaxInFlight)
+}
+
+func TestNewConfigRejectsInvalidSettings(t *testing.T) {
+	_, err := NewConfig(Config{}, WithMaxInFlight(0))
+	assert.Error(t, err)
+
+	_, err = NewConfig(Config{}, WithKafkaVersionString("not-a-version"))
+	assert.Error(t, err)
+
+	_, err = NewConfig(Config{}, WithFetchSizes(0, 0, 0))
+	assert.Error(t, err)
+}
+
+func TestNewConfigTransactional(t *testing.T) {
+	c, err := NewConfig(Config{}, WithTransactionalID("txn"), WithReadCommitted())
+	assert.NoError(t, err)
+	assert.Equal(t, "txn", c.Sarama.Producer.Transaction.ID)
+	assert.True(t, c.Sarama.Producer.Idempotent)
+	assert.Equal(t, sarama.WaitForAll, c.Sarama.Producer.RequiredAcks)
+	assert.Equal(t, 1, c.Sarama.Net.MaxOpenRequests)
+	assert.Equal(t, sarama.ReadCommitted, c.Sarama.Consumer.IsolationLevel)
+
+	_, err = NewConfig(Config{}, WithTransactionalID(""))
+	assert.Error(t, err)
+}
@@ -0,0 +1,37 @@
+package shared
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrInvalidMessage is reported if a message is nil or cannot be converted to a sarama.ProducerMessage.
+	ErrInvalidMessage = errors.New("producer: invalid message")
+	// ErrProducerClosed is returned when sending on a producer that has been closed.
+	ErrProducerClosed = errors.New("producer: closed")
+	// ErrQueueFull is returned when the number of unacknowledged messages reached the in-flight limit.
+	ErrQueueFull = errors.New("producer: in-flight limit reached")
+	// ErrNotTransactional is returned by the transaction methods if the producer was created without WithTransactionalID.
+	ErrNotTransactional = errors.New("producer: not transactional")
+)
+
+// DeliveryReport describes the outcome of producing a single message.
+type DeliveryReport struct {
+	// Partition is the partition the message was written to.
+	Partition int32
+	// Offset is the offset the message was written at.
+	Offset int64
+	// Err is set if the message could not be produced.
+	Err error
+}
+
+// DeliveryCallback is invoked exactly once per message, after the broker acknowledged or rejected it.
+// It is called from the producer's handler goroutines and must not block.
+type DeliveryCallback func(report DeliveryReport)
+
+// TransactionalProducer atomically produces messages and commits consumed offsets, it is implemented by producer.Producer.
+// Consumers depend on it instead of the producer package.
+type TransactionalProducer interface {
+	SendMessagesWithOffsets(ctx context.Context, messages []*KafkaMessage, groupID string, consumed []*KafkaMessage) error
+}
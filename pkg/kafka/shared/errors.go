@@ -0,0 +1,173 @@
+package shared
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/IBM/sarama"
+	"io"
+	"net"
+	"syscall"
+)
+
+// ErrorClass tells how a consumer or producer reacts to an error.
+type ErrorClass int
+
+const (
+	// ErrorClassRetriable errors are transient, for example network failures or rebalances, and are retried with backoff.
+	ErrorClassRetriable ErrorClass = iota
+	// ErrorClassAuth errors are authentication or authorization failures, they need a change of credentials or ACLs.
+	ErrorClassAuth
+	// ErrorClassConfig errors are caused by an invalid configuration or an unsupported broker version.
+	ErrorClassConfig
+	// ErrorClassFatal errors are all other errors, the client cannot continue.
+	ErrorClassFatal
+)
+
+// String returns the name of the class.
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorClassRetriable:
+		return "retriable"
+	case ErrorClassAuth:
+		return "auth"
+	case ErrorClassConfig:
+		return "config"
+	default:
+		return "fatal"
+	}
+}
+
+var retriableErrors = []error{
+	context.Canceled,
+	context.DeadlineExceeded,
+	io.EOF,
+	io.ErrUnexpectedEOF,
+	syscall.ECONNREFUSED,
+	syscall.ECONNRESET,
+	syscall.EPIPE,
+	sarama.ErrOutOfBrokers,
+	sarama.ErrNotConnected,
+	sarama.ErrBrokerNotFound,
+	sarama.ErrControllerNotAvailable,
+	sarama.ErrIncompleteResponse,
+	sarama.ErrLeaderNotAvailable,
+	sarama.ErrNotLeaderForPartition,
+	sarama.ErrRequestTimedOut,
+	sarama.ErrBrokerNotAvailable,
+	sarama.ErrReplicaNotAvailable,
+	sarama.ErrNetworkException,
+	sarama.ErrOffsetsLoadInProgress,
+	sarama.ErrConsumerCoordinatorNotAvailable,
+	sarama.ErrNotCoordinatorForConsumer,
+	sarama.ErrNotEnoughReplicas,
+	sarama.ErrNotEnoughReplicasAfterAppend,
+	sarama.ErrIllegalGeneration,
+	sarama.ErrUnknownMemberId,
+	sarama.ErrRebalanceInProgress,
+	sarama.ErrUnknownTopicOrPartition,
+	sarama.ErrKafkaStorageError,
+	sarama.ErrFetchSessionIDNotFound,
+	sarama.ErrInvalidFetchSessionEpoch,
+	sarama.ErrFencedLeaderEpoch,
+	sarama.ErrUnknownLeaderEpoch,
+	sarama.ErrOffsetNotAvailable,
+	sarama.ErrMemberIdRequired,
+	sarama.ErrPreferredLeaderNotAvailable,
+	sarama.ErrUnstableOffsetCommit,
+	sarama.ErrThrottlingQuotaExceeded,
+}
+
+var authErrors = []error{
+	sarama.ErrTopicAuthorizationFailed,
+	sarama.ErrGroupAuthorizationFailed,
+	sarama.ErrClusterAuthorizationFailed,
+	sarama.ErrIllegalSASLState,
+	sarama.ErrTransactionalIDAuthorizationFailed,
+	sarama.ErrSASLAuthenticationFailed,
+	sarama.ErrDelegationTokenAuthorizationFailed,
+	sarama.ErrDelegationTokenExpired,
+	sarama.ErrUnknownScramMechanism,
+}
+
+var configErrors = []error{
+	sarama.ErrInvalidTopic,
+	sarama.ErrInconsistentGroupProtocol,
+	sarama.ErrInvalidGroupId,
+	sarama.ErrInvalidSessionTimeout,
+	sarama.ErrUnsupportedSASLMechanism,
+	sarama.ErrUnsupportedVersion,
+	sarama.ErrInvalidConfig,
+	sarama.ErrUnsupportedForMessageFormat,
+	sarama.ErrSecurityDisabled,
+	sarama.ErrUnsupportedCompressionType,
+	sarama.ErrGroupMaxSizeReached,
+	sarama.ErrNonTransactedProducer,
+}
+
+// ClassifyError returns the class of err, looking through wrapped and joined errors.
+// Errors the classification does not know are fatal, errors combining several classes get the most severe one.
+func ClassifyError(err error) ErrorClass {
+	var consumerErrors sarama.ConsumerErrors
+	if errors.As(err, &consumerErrors) && len(consumerErrors) > 0 {
+		class := ErrorClassRetriable
+		for _, e := range consumerErrors {
+			class = max(class, ClassifyError(e))
+		}
+		return class
+	}
+	if isAny(err, authErrors) {
+		return ErrorClassAuth
+	}
+	var certificateError *tls.CertificateVerificationError
+	if errors.As(err, &certificateError) {
+		return ErrorClassAuth
+	}
+	var configurationError sarama.ConfigurationError
+	if errors.As(err, &configurationError) || isAny(err, configErrors) {
+		return ErrorClassConfig
+	}
+	if isAny(err, retriableErrors) {
+		return ErrorClassRetriable
+	}
+	var netError net.Error
+	if errors.As(err, &netError) {
+		return ErrorClassRetriable
+	}
+	return ErrorClassFatal
+}
+
+// IsRetriable returns whether err is transient and the operation should be retried.
+func IsRetriable(err error) bool {
+	return ClassifyError(err) == ErrorClassRetriable
+}
+
+func isAny(err error, targets []error) bool {
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassifiedError is an error together with its class, as reported by the Errors channel of the consumers.
+type ClassifiedError struct {
+	Class ErrorClass
+	Err   error
+}
+
+// NewClassifiedError classifies err.
+func NewClassifiedError(err error) *ClassifiedError {
+	return &ClassifiedError{Class: ClassifyError(err), Err: err}
+}
+
+// Error returns the class and message of the error.
+func (e *ClassifiedError) Error() string {
+	return e.Class.String() + " error: " + e.Err.Error()
+}
+
+// Unwrap returns the classified error.
+func (e *ClassifiedError) Unwrap() error {
+	return e.Err
+}
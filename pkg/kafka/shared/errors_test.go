@@ -0,0 +1,37 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err   error
+		class ErrorClass
+	}{
+		{sarama.ErrOutOfBrokers, ErrorClassRetriable},
+		{fmt.Errorf("session: %w", sarama.ErrRebalanceInProgress), ErrorClassRetriable},
+		{context.Canceled, ErrorClassRetriable},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, ErrorClassRetriable},
+		{sarama.ErrSASLAuthenticationFailed, ErrorClassAuth},
+		{errors.Join(sarama.ErrOutOfBrokers, sarama.ErrTopicAuthorizationFailed), ErrorClassAuth},
+		{sarama.ConfigurationError("no brokers"), ErrorClassConfig},
+		{sarama.ErrUnsupportedVersion, ErrorClassConfig},
+		{sarama.ConsumerErrors{{Err: sarama.ErrNotLeaderForPartition}, {Err: sarama.ErrInvalidGroupId}}, ErrorClassConfig},
+		{sarama.ErrClosedConsumerGroup, ErrorClassFatal},
+		{errors.New("unexpected"), ErrorClassFatal},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.class, ClassifyError(test.err), test.err.Error())
+	}
+
+	classified := NewClassifiedError(sarama.ErrGroupAuthorizationFailed)
+	assert.ErrorIs(t, classified, sarama.ErrGroupAuthorizationFailed)
+	assert.Contains(t, classified.Error(), "auth error")
+}
@@ -0,0 +1,145 @@
+package shared
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+)
+
+// Handler processes a single message.
+// Returning nil marks the message as processed, returning an error leaves it unmarked.
+type Handler func(ctx context.Context, message *KafkaMessage) error
+
+// Ordering selects which messages Run processes sequentially.
+type Ordering int
+
+const (
+	// OrderByPartition processes messages of the same topic partition in order.
+	OrderByPartition Ordering = iota
+	// OrderByKey processes messages with the same key in order, messages without a key are ordered by partition.
+	OrderByKey
+)
+
+// DefaultWorkerQueueSize is the default capacity of the queue in front of every Run worker.
+const DefaultWorkerQueueSize = 100
+
+// RunOptions configures Run.
+type RunOptions struct {
+	// Workers is the number of goroutines calling the handler, it defaults to 1.
+	Workers int
+	// Ordering selects which messages are processed sequentially by the same worker.
+	Ordering Ordering
+	// WorkerQueueSize is the capacity of the queue in front of every worker, it defaults to DefaultWorkerQueueSize.
+	WorkerQueueSize int
+	// OnError is called when the handler returns an error. The message is not marked, which stalls commits of its partition, see Run.
+	OnError func(message *KafkaMessage, err error)
+	// StopOnError makes Run return the first error returned by the handler.
+	StopOnError bool
+}
+
+// Run reads messages and passes them to handler on a pool of workers until ctx is done or messages is closed.
+// Messages with the same ordering key are always handled by the same worker, in the order they were received.
+// Messages for which the handler returns nil are passed to mark.
+// Run returns ctx.Err() once ctx is done, nil if messages was closed, or the handler error if StopOnError is set.
+//
+// A message for which the handler returns an error stays unmarked. The consumers only commit up to the oldest
+// unmarked message of a partition, so the partition stops committing until the message is redelivered after the
+// next rebalance or restart, and its lag grows although later messages are processed. Wrap handler with
+// dlq.DeadLetterQueue.Handler to re-publish failed messages instead, or watch OffsetStats.Blocking and AckedAhead.
+func Run(ctx context.Context, messages <-chan *KafkaMessage, mark func(*KafkaMessage), handler Handler, opts RunOptions) error {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	queueSize := opts.WorkerQueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultWorkerQueueSize
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var handlerErr error
+	var handlerErrOnce sync.Once
+	var wg sync.WaitGroup
+	queues := make([]chan *KafkaMessage, workers)
+	for i := range queues {
+		queues[i] = make(chan *KafkaMessage, queueSize)
+		wg.Add(1)
+		go func(queue <-chan *KafkaMessage) {
+			defer wg.Done()
+			for message := range queue {
+				if runCtx.Err() != nil {
+					// Skipped messages stay unmarked and are redelivered after a restart.
+					continue
+				}
+				err := handler(runCtx, message)
+				if err == nil {
+					mark(message)
+					continue
+				}
+				if opts.OnError != nil {
+					opts.OnError(message, err)
+				}
+				if opts.StopOnError {
+					handlerErrOnce.Do(func() {
+						handlerErr = err
+						cancel()
+					})
+				}
+			}
+		}(queues[i])
+	}
+
+	err := dispatch(runCtx, messages, queues, opts.Ordering)
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	if handlerErr != nil {
+		return handlerErr
+	}
+	if err != nil {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// dispatch distributes messages to the queues until ctx is done or messages is closed.
+func dispatch(ctx context.Context, messages <-chan *KafkaMessage, queues []chan *KafkaMessage, ordering Ordering) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case message, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			if message == nil {
+				continue
+			}
+			queue := queues[orderingHash(message, ordering)%uint32(len(queues))]
+			select {
+			case queue <- message:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// orderingHash returns the hash of the ordering key of message.
+func orderingHash(message *KafkaMessage, ordering Ordering) uint32 {
+	hasher := fnv.New32a()
+	if ordering == OrderByKey && len(message.Key) > 0 {
+		hasher.Write(message.Key)
+		return hasher.Sum32()
+	}
+	hasher.Write([]byte(message.Topic))
+	var partition [4]byte
+	binary.BigEndian.PutUint32(partition[:], uint32(message.Partition))
+	hasher.Write(partition[:])
+	return hasher.Sum32()
+}
@@ -0,0 +1,87 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunKeepsPartitionOrder(t *testing.T) {
+	messages := make(chan *KafkaMessage, 1000)
+	for offset := int64(0); offset < 100; offset++ {
+		for partition := int32(0); partition < 4; partition++ {
+			messages <- &KafkaMessage{Topic: "topic", Partition: partition, Offset: offset}
+		}
+	}
+	close(messages)
+
+	var mutex sync.Mutex
+	handled := make(map[int32][]int64)
+	marked := 0
+	err := Run(context.Background(), messages, func(*KafkaMessage) {
+		mutex.Lock()
+		marked++
+		mutex.Unlock()
+	}, func(_ context.Context, message *KafkaMessage) error {
+		mutex.Lock()
+		handled[message.Partition] = append(handled[message.Partition], message.Offset)
+		mutex.Unlock()
+		return nil
+	}, RunOptions{Workers: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 400, marked)
+
+	for partition, offsets := range handled {
+		assert.Len(t, offsets, 100, "partition %d", partition)
+		for i, offset := range offsets {
+			assert.Equal(t, int64(i), offset, "partition %d", partition)
+		}
+	}
+}
+
+func TestRunDoesNotMarkFailedMessages(t *testing.T) {
+	messages := make(chan *KafkaMessage, 10)
+	messages <- &KafkaMessage{Topic: "topic", Offset: 0}
+	messages <- &KafkaMessage{Topic: "topic", Offset: 1}
+	close(messages)
+
+	errFailed := errors.New("failed")
+	var marked []int64
+	var failed []int64
+	err := Run(context.Background(), messages, func(message *KafkaMessage) {
+		marked = append(marked, message.Offset)
+	}, func(_ context.Context, message *KafkaMessage) error {
+		if message.Offset == 1 {
+			return errFailed
+		}
+		return nil
+	}, RunOptions{OnError: func(message *KafkaMessage, err error) {
+		failed = append(failed, message.Offset)
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0}, marked)
+	assert.Equal(t, []int64{1}, failed)
+}
+
+func TestRunStopOnError(t *testing.T) {
+	messages := make(chan *KafkaMessage, 10)
+	messages <- &KafkaMessage{Topic: "topic", Offset: 0}
+
+	errFailed := errors.New("failed")
+	err := Run(context.Background(), messages, func(*KafkaMessage) {}, func(context.Context, *KafkaMessage) error {
+		return errFailed
+	}, RunOptions{StopOnError: true})
+	assert.ErrorIs(t, err, errFailed)
+}
+
+func TestRunReturnsOnContextDone(t *testing.T) {
+	ctx, cncl := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cncl()
+	err := Run(ctx, make(chan *KafkaMessage), func(*KafkaMessage) {}, func(context.Context, *KafkaMessage) error {
+		return nil
+	}, RunOptions{Workers: 2})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,128 @@
+package shared
+
+import (
+	"github.com/IBM/sarama"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health is a snapshot of the state of a producer or consumer, the health package turns it into probes.
+type Health struct {
+	// Healthy is false once the client stopped for good, for example because it was closed or hit a terminal error.
+	Healthy bool `json:"healthy"`
+	// Ready is true while the client can do its work, for consumers while they hold a consumer group session.
+	Ready bool `json:"ready"`
+	// Reason explains why the client is not healthy or not ready.
+	Reason string `json:"reason,omitempty"`
+	// ConnectedBrokers is the number of brokers with an open connection.
+	ConnectedBrokers int `json:"connected_brokers"`
+	// GroupState is the state of the consumer group, if known.
+	GroupState string `json:"group_state,omitempty"`
+	// LastFetch is when the last message was consumed, it is zero for producers and consumers that did not consume yet.
+	LastFetch time.Time `json:"last_fetch"`
+	// LastCommit is when offsets were last committed or, with auto-commit, handed to sarama for the next commit.
+	LastCommit time.Time `json:"last_commit"`
+	// ErrorRate is the share of produced messages that failed recently, it is zero for consumers.
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// Activity records when a consumer last fetched a message and committed offsets.
+type Activity struct {
+	lastFetch  atomic.Int64
+	lastCommit atomic.Int64
+}
+
+// Fetched records that a message was consumed.
+func (a *Activity) Fetched() {
+	a.lastFetch.Store(time.Now().UnixNano())
+}
+
+// Committed records that offsets were committed.
+func (a *Activity) Committed() {
+	a.lastCommit.Store(time.Now().UnixNano())
+}
+
+// LastFetch returns when a message was last consumed, or the zero time.
+func (a *Activity) LastFetch() time.Time {
+	return unixNano(a.lastFetch.Load())
+}
+
+// LastCommit returns when offsets were last committed, or the zero time.
+func (a *Activity) LastCommit() time.Time {
+	return unixNano(a.lastCommit.Load())
+}
+
+func unixNano(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// ConnectedBrokers returns the number of brokers of client with an open connection.
+// sarama connects to brokers lazily, so idle clients may report fewer brokers than the cluster has.
+func ConnectedBrokers(client sarama.Client) int {
+	if client == nil || client.Closed() {
+		return 0
+	}
+	connected := 0
+	for _, broker := range client.Brokers() {
+		if ok, _ := broker.Connected(); ok {
+			connected++
+		}
+	}
+	return connected
+}
+
+// ErrorRate tracks the share of failed operations over a sliding window.
+// It counts the current and the previous window, so the rate does not drop to zero whenever a window starts.
+type ErrorRate struct {
+	mutex    sync.Mutex
+	window   time.Duration
+	start    time.Time
+	current  [2]uint64
+	previous [2]uint64
+}
+
+// NewErrorRate returns an ErrorRate over window.
+func NewErrorRate(window time.Duration) *ErrorRate {
+	return &ErrorRate{window: window, start: time.Now()}
+}
+
+// Record counts an operation.
+func (r *ErrorRate) Record(failed bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rotate()
+	r.current[0]++
+	if failed {
+		r.current[1]++
+	}
+}
+
+// Rate returns the share of failed operations between 0 and 1, it is 0 without operations.
+func (r *ErrorRate) Rate() float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rotate()
+	total := r.current[0] + r.previous[0]
+	if total == 0 {
+		return 0
+	}
+	return float64(r.current[1]+r.previous[1]) / float64(total)
+}
+
+func (r *ErrorRate) rotate() {
+	elapsed := time.Since(r.start)
+	if elapsed < r.window {
+		return
+	}
+	if elapsed < 2*r.window {
+		r.previous = r.current
+	} else {
+		r.previous = [2]uint64{}
+	}
+	r.current = [2]uint64{}
+	r.start = time.Now()
+}
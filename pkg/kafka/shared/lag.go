@@ -0,0 +1,101 @@
+package shared
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"time"
+)
+
+// Lag describes how far a consumer group is behind on a partition.
+type Lag struct {
+	// Committed is the committed offset of the group, i.e. the next offset it consumes. It is -1 if nothing was committed yet.
+	Committed int64
+	// HighWaterMark is the offset the next produced message will get.
+	HighWaterMark int64
+	// Lag is the number of messages between Committed and HighWaterMark.
+	// If nothing was committed yet, it counts all messages still retained in the partition.
+	Lag int64
+}
+
+// LagCallback receives the result of every lag poll.
+type LagCallback func(lag map[TopicPartition]Lag, err error)
+
+// GetLag compares the committed offsets of group with the high-water marks of all partitions of topics.
+func GetLag(ctx context.Context, client sarama.Client, group string, topics []string) (map[TopicPartition]Lag, error) {
+	request := &sarama.OffsetFetchRequest{Version: 1, ConsumerGroup: group}
+	result := make(map[TopicPartition]Lag)
+	for _, topic := range topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			return nil, err
+		}
+		for _, partition := range partitions {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+			highWaterMark, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, err
+			}
+			request.AddPartition(topic, partition)
+			result[TopicPartition{Topic: topic, Partition: partition}] = Lag{Committed: -1, HighWaterMark: highWaterMark}
+		}
+	}
+	if len(result) == 0 {
+		return result, nil
+	}
+
+	coordinator, err := client.Coordinator(group)
+	if err != nil {
+		return nil, err
+	}
+	response, err := coordinator.FetchOffset(request)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, lag := range result {
+		block := response.GetBlock(key.Topic, key.Partition)
+		if block == nil {
+			return nil, sarama.ErrIncompleteResponse
+		}
+		if block.Err != sarama.ErrNoError {
+			return nil, block.Err
+		}
+		lag.Committed = block.Offset
+		start := lag.Committed
+		if start < 0 {
+			if err = ctx.Err(); err != nil {
+				return nil, err
+			}
+			start, err = client.GetOffset(key.Topic, key.Partition, sarama.OffsetOldest)
+			if err != nil {
+				return nil, err
+			}
+		}
+		lag.Lag = lag.HighWaterMark - start
+		if lag.Lag < 0 {
+			lag.Lag = 0
+		}
+		result[key] = lag
+	}
+	return result, nil
+}
+
+// PollLag calls get every interval and passes the result to callback until ctx is done.
+func PollLag(ctx context.Context, interval time.Duration, get func(ctx context.Context) (map[TopicPartition]Lag, error), callback LagCallback) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		lag, err := get(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		callback(lag, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
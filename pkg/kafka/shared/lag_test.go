@@ -0,0 +1,64 @@
+package shared
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetLag(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("topic", 0, broker.BrokerID()).
+			SetLeader("topic", 1, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("topic", 0, sarama.OffsetNewest, 100).
+			SetOffset("topic", 1, sarama.OffsetNewest, 50).
+			SetOffset("topic", 1, sarama.OffsetOldest, 20),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "group", broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("group", "topic", 0, 90, "", sarama.ErrNoError).
+			SetOffset("group", "topic", 1, -1, "", sarama.ErrNoError),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_3_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	lag, err := GetLag(context.Background(), client, "group", []string{"topic"})
+	assert.NoError(t, err)
+	assert.Equal(t, Lag{Committed: 90, HighWaterMark: 100, Lag: 10}, lag[TopicPartition{Topic: "topic", Partition: 0}])
+	assert.Equal(t, Lag{Committed: -1, HighWaterMark: 50, Lag: 30}, lag[TopicPartition{Topic: "topic", Partition: 1}])
+
+	var polls atomic.Int32
+	ctx, cncl := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		PollLag(ctx, 10*time.Millisecond, func(ctx context.Context) (map[TopicPartition]Lag, error) {
+			return GetLag(ctx, client, "group", []string{"topic"})
+		}, func(lag map[TopicPartition]Lag, err error) {
+			assert.NoError(t, err)
+			assert.Len(t, lag, 2)
+			if polls.Add(1) == 3 {
+				cncl()
+			}
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("poller did not stop")
+	}
+}
@@ -0,0 +1,110 @@
+package shared
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"log/slog"
+	"sort"
+)
+
+// WithLogger makes the producer or consumer log to logger instead of the global zap logger.
+// Consumers add their group ID and, per session and claim, member ID, generation, topic and partition as fields.
+func WithLogger(logger *zap.Logger) Option {
+	return func(c *Config) error {
+		if logger == nil {
+			logger = zap.NewNop()
+		}
+		c.Logger = logger
+		return nil
+	}
+}
+
+// WithSlogHandler makes the producer or consumer log to handler, see WithLogger.
+func WithSlogHandler(handler slog.Handler) Option {
+	return func(c *Config) error {
+		c.Logger = zap.New(NewSlogCore(handler))
+		return nil
+	}
+}
+
+// SaramaLogger returns a logger for sarama's internal messages that writes to logger.
+// sarama only supports a process-wide logger, so the producers and consumers leave it alone;
+// assign the result to sarama.Logger to see sarama's logs.
+func SaramaLogger(logger *zap.Logger) sarama.StdLogger {
+	return zap.NewStdLog(logger.Named("sarama"))
+}
+
+// slogCore is a zapcore.Core writing to a slog.Handler.
+type slogCore struct {
+	handler slog.Handler
+}
+
+// NewSlogCore returns a zapcore.Core that writes to handler, so zap loggers can feed slog based logging setups.
+func NewSlogCore(handler slog.Handler) zapcore.Core {
+	return &slogCore{handler: handler}
+}
+
+// Enabled returns whether the handler accepts records of level.
+func (s *slogCore) Enabled(level zapcore.Level) bool {
+	return s.handler.Enabled(context.Background(), slogLevel(level))
+}
+
+// With returns a core that adds fields to every record.
+func (s *slogCore) With(fields []zapcore.Field) zapcore.Core {
+	return &slogCore{handler: s.handler.WithAttrs(slogAttrs(fields))}
+}
+
+// Check adds the core to checked if the entry is enabled.
+func (s *slogCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if s.Enabled(entry.Level) {
+		return checked.AddCore(entry, s)
+	}
+	return checked
+}
+
+// Write passes the entry to the handler.
+func (s *slogCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	record := slog.NewRecord(entry.Time, slogLevel(entry.Level), entry.Message, 0)
+	if entry.LoggerName != "" {
+		record.AddAttrs(slog.String("logger", entry.LoggerName))
+	}
+	record.AddAttrs(slogAttrs(fields)...)
+	return s.handler.Handle(context.Background(), record)
+}
+
+// Sync does nothing, slog handlers have no flush.
+func (s *slogCore) Sync() error {
+	return nil
+}
+
+func slogLevel(level zapcore.Level) slog.Level {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return slog.LevelDebug
+	case level == zapcore.InfoLevel:
+		return slog.LevelInfo
+	case level == zapcore.WarnLevel:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+func slogAttrs(fields []zapcore.Field) []slog.Attr {
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+	keys := make([]string, 0, len(encoder.Fields))
+	for key := range encoder.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, slog.Any(key, encoder.Fields[key]))
+	}
+	return attrs
+}
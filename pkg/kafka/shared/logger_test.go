@@ -0,0 +1,26 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"testing"
+)
+
+func TestWithSlogHandler(t *testing.T) {
+	var buffer bytes.Buffer
+	config, err := NewConfig(Config{}, WithSlogHandler(slog.NewJSONHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	assert.NoError(t, err)
+
+	log := config.Logger.Sugar().With("group_id", "group")
+	log.Debugf("dropped")
+	log.With("partition", 3).Warnf("lagging by %d", 10)
+
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &record))
+	assert.Equal(t, "WARN", record["level"])
+	assert.Equal(t, "lagging by 10", record["msg"])
+	assert.Equal(t, "group", record["group_id"])
+	assert.Equal(t, float64(3), record["partition"])
+}
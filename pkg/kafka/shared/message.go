@@ -1,8 +1,11 @@
 package shared
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/IBM/sarama"
-	"go.uber.org/zap"
+	"go.opentelemetry.io/otel/propagation"
 	"time"
 )
 
@@ -18,6 +21,9 @@ type KafkaMessage struct {
 	Partition int32             `json:"partition"`
 	Metadata  Metadata          `json:"metadata"`
 	Tracing   Tracing           `json:"tracing"`
+	// Context carries the OpenTelemetry span context of the message. Consumers set it to the propagated context,
+	// producers propagate it in the traceparent and tracestate headers. It is nil if the message is not traced.
+	Context context.Context `json:"-"`
 }
 
 type Metadata struct {
@@ -31,19 +37,32 @@ type Tracing struct {
 
 // FromConsumerMessage converts a sarama.ConsumerMessage to a KafkaMessage.
 func FromConsumerMessage(message *sarama.ConsumerMessage) *KafkaMessage {
+	return fromConsumerMessage(message, true, defaultPropagator)
+}
+
+// FromConsumerMessageWithoutHeaders converts a sarama.ConsumerMessage to a KafkaMessage without decoding its headers.
+// Headers and Tracing stay empty, which saves the allocations for high-throughput consumers that do not need them.
+func FromConsumerMessageWithoutHeaders(message *sarama.ConsumerMessage) *KafkaMessage {
+	return fromConsumerMessage(message, false, nil)
+}
+
+func fromConsumerMessage(message *sarama.ConsumerMessage, decodeHeaders bool, propagator propagation.TextMapPropagator) *KafkaMessage {
 	if message == nil {
 		return nil
 	}
 	m := &KafkaMessage{
-		Headers:   make(map[string]string, len(message.Headers)),
 		Key:       message.Key,
 		Value:     message.Value,
 		Topic:     message.Topic,
 		Partition: message.Partition,
 		Offset:    message.Offset,
 	}
-	for _, header := range message.Headers {
-		m.Headers[string(header.Key)] = string(header.Value)
+	if decodeHeaders {
+		m.Headers = make(map[string]string, len(message.Headers))
+		for _, header := range message.Headers {
+			m.Headers[string(header.Key)] = string(header.Value)
+		}
+		m.Context = extractContext(propagator, m.Headers)
 	}
 	metadata := Metadata{
 		// This is the timestamp the message was inserted into the topic, not the timestamp of the message itself.
@@ -75,6 +94,7 @@ func ToConsumerMessage(message *KafkaMessage) *sarama.ConsumerMessage {
 		Topic:     message.Topic,
 		Partition: message.Partition,
 		Offset:    message.Offset,
+		Timestamp: message.Metadata.Timestamp,
 	}
 	m.Headers = make([]*sarama.RecordHeader, 0, len(message.Headers))
 	for k, v := range message.Headers {
@@ -87,25 +107,37 @@ func ToConsumerMessage(message *KafkaMessage) *sarama.ConsumerMessage {
 }
 
 // ToProducerMessage converts a KafkaMessage to a sarama.ProducerMessage.
-// It ignores the Partition and Offset fields and sets trace headers.
+// It ignores the Offset field and sets trace headers, including traceparent and tracestate if message.Context carries a span.
+// Partition is only honored by the explicit partitioner, see WithExplicitPartitioner.
+// It returns nil if message is nil or its trace header cannot be encoded.
 func ToProducerMessage(message *KafkaMessage) *sarama.ProducerMessage {
+	m, _ := toProducerMessage(message, defaultPropagator, DefaultTraceOptions)
+	return m
+}
+
+func toProducerMessage(message *KafkaMessage, propagator propagation.TextMapPropagator, trace TraceOptions) (*sarama.ProducerMessage, error) {
 	if message == nil {
-		return nil
+		return nil, errors.New("message is nil")
 	}
+	injectContext(propagator, message)
 	if v, _ := GetSXOrigin(message); !v {
 		AddSXOrigin(message)
 	}
-	if v, _ := GetSXTrace(message); !v {
-		err := AddSXTrace(message)
-		if err != nil {
-			zap.S().Errorf("failed to add trace header: %s", err)
-			return nil
-		}
+	// A forwarded x-trace header keeps its hops, but is bounded and encoded like a new one.
+	var err error
+	if v, _ := GetSXTrace(message); v {
+		err = boundSXTrace(message, trace)
+	} else {
+		err = addSXTrace(message, trace)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to add trace header: %w", err)
 	}
 	m := &sarama.ProducerMessage{
-		Topic: message.Topic,
-		Key:   sarama.ByteEncoder(message.Key),
-		Value: sarama.ByteEncoder(message.Value),
+		Topic:     message.Topic,
+		Key:       sarama.ByteEncoder(message.Key),
+		Value:     sarama.ByteEncoder(message.Value),
+		Partition: message.Partition,
 	}
 	m.Headers = make([]sarama.RecordHeader, 0, len(message.Headers))
 	for k, v := range message.Headers {
@@ -114,5 +146,5 @@ func ToProducerMessage(message *KafkaMessage) *sarama.ProducerMessage {
 			Value: []byte(v),
 		})
 	}
-	return m
+	return m, nil
 }
@@ -4,6 +4,7 @@ import (
 	"github.com/IBM/sarama"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestConsumerMessage(t *testing.T) {
@@ -26,3 +27,34 @@ func TestConsumerMessage(t *testing.T) {
 
 	assert.Equal(t, *c, *cX)
 }
+
+func TestConsumerMessageFidelity(t *testing.T) {
+	c := &sarama.ConsumerMessage{
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+		Topic:     "topic",
+		Partition: 1,
+		Offset:    2,
+		Timestamp: time.UnixMilli(1700000000000),
+		Headers: []*sarama.RecordHeader{
+			{
+				Key:   []byte("x-origin"),
+				Value: []byte("origin"),
+			},
+		},
+	}
+
+	m := FromConsumerMessage(c)
+	assert.Equal(t, "origin", m.Headers["x-origin"])
+	assert.Equal(t, "origin", m.Tracing.OriginId)
+	assert.Equal(t, c.Timestamp, m.Metadata.Timestamp)
+
+	withoutHeaders := FromConsumerMessageWithoutHeaders(c)
+	assert.Nil(t, withoutHeaders.Headers)
+	assert.Equal(t, "", withoutHeaders.Tracing.OriginId)
+	assert.Equal(t, c.Timestamp, withoutHeaders.Metadata.Timestamp)
+
+	config, err := NewConfig(Config{}, WithoutHeaderDecoding())
+	assert.NoError(t, err)
+	assert.Equal(t, withoutHeaders, config.FromConsumerMessage(c))
+}
@@ -0,0 +1,66 @@
+package shared
+
+import (
+	"errors"
+	"github.com/rcrowley/go-metrics"
+	"time"
+)
+
+// MetricsRecorder receives the telemetry of producers and consumers, see the metrics package for a Prometheus implementation.
+// All methods are called from hot paths and must not block.
+type MetricsRecorder interface {
+	// MessageProduced is called once the broker acknowledged a message.
+	MessageProduced(topic string, partition int32)
+	// MessageProduceFailed is called once a message could not be produced after all retries.
+	MessageProduceFailed(topic string, partition int32)
+	// MessageConsumed is called when a message is handed out by a consumer.
+	MessageConsumed(topic string, partition int32)
+	// MessageMarked is called when a message is marked as processed.
+	MessageMarked(topic string, partition int32)
+	// OffsetMarked is called when the offset of a partition is marked, it is committed with the next commit.
+	OffsetMarked(topic string, partition int32, offset int64)
+	// OffsetCommitted is called for every partition whose offset was included in a commit, once the commit returned.
+	OffsetCommitted(topic string, partition int32, offset int64)
+	// CommitDuration is called after every commit with the time it took.
+	CommitDuration(duration time.Duration)
+	// ConsumerLag is called with the number of messages between a consumed message and the end of its partition.
+	ConsumerLag(topic string, partition int32, lag int64)
+	// Rebalance is called whenever a consumer group session starts.
+	Rebalance(group string)
+	// RegisterChannel registers a function returning the length and capacity of a message channel of owner.
+	// The returned function unregisters it again.
+	RegisterChannel(owner, channel string, fill func() (length, capacity int)) (unregister func())
+	// RegisterSaramaRegistry registers the go-metrics registry of a sarama client.
+	// The returned function unregisters it again.
+	RegisterSaramaRegistry(clientID string, registry metrics.Registry) (unregister func())
+}
+
+// NoopMetricsRecorder is a MetricsRecorder discarding everything, it is used if no recorder is configured.
+type NoopMetricsRecorder struct{}
+
+func (NoopMetricsRecorder) MessageProduced(string, int32)        {}
+func (NoopMetricsRecorder) MessageProduceFailed(string, int32)   {}
+func (NoopMetricsRecorder) MessageConsumed(string, int32)        {}
+func (NoopMetricsRecorder) MessageMarked(string, int32)          {}
+func (NoopMetricsRecorder) OffsetMarked(string, int32, int64)    {}
+func (NoopMetricsRecorder) OffsetCommitted(string, int32, int64) {}
+func (NoopMetricsRecorder) CommitDuration(time.Duration)         {}
+func (NoopMetricsRecorder) ConsumerLag(string, int32, int64)     {}
+func (NoopMetricsRecorder) Rebalance(string)                     {}
+func (NoopMetricsRecorder) RegisterChannel(string, string, func() (int, int)) func() {
+	return func() {}
+}
+func (NoopMetricsRecorder) RegisterSaramaRegistry(string, metrics.Registry) func() {
+	return func() {}
+}
+
+// WithMetrics makes producers and consumers report their telemetry to recorder.
+func WithMetrics(recorder MetricsRecorder) Option {
+	return func(c *Config) error {
+		if recorder == nil {
+			return errors.New("metrics recorder must not be nil")
+		}
+		c.Metrics = recorder
+		return nil
+	}
+}
@@ -0,0 +1,173 @@
+package shared
+
+import (
+	"sort"
+	"sync"
+)
+
+// TopicPartition identifies a partition of a topic.
+type TopicPartition struct {
+	Topic     string
+	Partition int32
+}
+
+// OffsetStats describes the commit progress of a single partition.
+type OffsetStats struct {
+	// Committable is the offset that is committed for the partition, i.e. the offset after the last
+	// contiguously processed message. It is -1 if nothing can be committed yet.
+	Committable int64
+	// Blocking is the lowest offset that has been consumed but not processed yet, -1 if there is none.
+	Blocking int64
+	// InFlight is the number of consumed messages that have not been processed yet.
+	InFlight int
+	// AckedAhead is the number of processed messages that cannot be committed because an older message is still in flight.
+	AckedAhead int
+}
+
+// OffsetTracker computes the highest contiguous processed offset per partition,
+// so messages can be acknowledged in any order without committing past an unprocessed message.
+// Every consumed message must be passed to Track before it is handed out, and to Ack once it has been processed.
+// A tracked message that is never acknowledged blocks the partition until Reset, Stats reports it as Blocking
+// while the messages acknowledged after it are counted as AckedAhead.
+type OffsetTracker struct {
+	mutex      sync.Mutex
+	partitions map[TopicPartition]*partitionOffsets
+}
+
+// partitionOffsets tracks the in-flight offsets of one partition in consumption order.
+type partitionOffsets struct {
+	// offsets holds the tracked offsets in ascending order, starting at head.
+	offsets []int64
+	head    int
+	// acked holds the tracked offsets that have been acknowledged.
+	acked       map[int64]struct{}
+	committable int64
+}
+
+// NewOffsetTracker returns an empty OffsetTracker.
+func NewOffsetTracker() *OffsetTracker {
+	return &OffsetTracker{
+		partitions: make(map[TopicPartition]*partitionOffsets),
+	}
+}
+
+// Track registers a consumed message.
+// If the offset is not greater than the last tracked offset of its partition, the partition was rewound and its state is reset.
+func (t *OffsetTracker) Track(message *KafkaMessage) {
+	if message == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	key := TopicPartition{Topic: message.Topic, Partition: message.Partition}
+	p, ok := t.partitions[key]
+	if !ok || (len(p.offsets) > p.head && p.offsets[len(p.offsets)-1] >= message.Offset) || p.committable > message.Offset {
+		p = &partitionOffsets{
+			acked:       make(map[int64]struct{}),
+			committable: -1,
+		}
+		t.partitions[key] = p
+	}
+	p.offsets = append(p.offsets, message.Offset)
+}
+
+// Ack registers a processed message and returns whether it was tracked.
+// Messages that were not tracked, for example because they belong to a previous session or were never delivered, are ignored.
+func (t *OffsetTracker) Ack(message *KafkaMessage) bool {
+	if message == nil {
+		return false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	p, ok := t.partitions[TopicPartition{Topic: message.Topic, Partition: message.Partition}]
+	if !ok || !p.tracked(message.Offset) {
+		return false
+	}
+	if _, acked := p.acked[message.Offset]; acked {
+		return true
+	}
+	p.acked[message.Offset] = struct{}{}
+
+	for p.head < len(p.offsets) {
+		offset := p.offsets[p.head]
+		if _, acked := p.acked[offset]; !acked {
+			break
+		}
+		delete(p.acked, offset)
+		p.committable = offset + 1
+		p.head++
+	}
+	// Compact the slice once the consumed prefix dominates it.
+	if p.head > 1024 && p.head > len(p.offsets)/2 {
+		p.offsets = append([]int64(nil), p.offsets[p.head:]...)
+		p.head = 0
+	}
+	return true
+}
+
+// tracked returns whether offset has been tracked and is not committable yet.
+func (p *partitionOffsets) tracked(offset int64) bool {
+	pending := p.offsets[p.head:]
+	i := sort.Search(len(pending), func(i int) bool {
+		return pending[i] >= offset
+	})
+	return i < len(pending) && pending[i] == offset
+}
+
+// CommittableOffset returns the offset to commit for a single partition, or false if it has no committable progress.
+// Unlike Committable, it does not allocate, so it can be called for every processed message.
+func (t *OffsetTracker) CommittableOffset(topic string, partition int32) (int64, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	p, ok := t.partitions[TopicPartition{Topic: topic, Partition: partition}]
+	if !ok || p.committable < 0 {
+		return -1, false
+	}
+	return p.committable, true
+}
+
+// Committable returns the offset to commit per partition, i.e. the offset after the last contiguously processed message.
+// Partitions without any committable progress are omitted.
+func (t *OffsetTracker) Committable() map[TopicPartition]int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	result := make(map[TopicPartition]int64, len(t.partitions))
+	for key, p := range t.partitions {
+		if p.committable >= 0 {
+			result[key] = p.committable
+		}
+	}
+	return result
+}
+
+// Stats returns the commit progress per partition.
+func (t *OffsetTracker) Stats() map[TopicPartition]OffsetStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	result := make(map[TopicPartition]OffsetStats, len(t.partitions))
+	for key, p := range t.partitions {
+		stats := OffsetStats{
+			Committable: p.committable,
+			Blocking:    -1,
+			InFlight:    len(p.offsets) - p.head - len(p.acked),
+			AckedAhead:  len(p.acked),
+		}
+		if p.head < len(p.offsets) {
+			stats.Blocking = p.offsets[p.head]
+		}
+		result[key] = stats
+	}
+	return result
+}
+
+// Reset forgets the state of all partitions, it is called when a new group session starts.
+func (t *OffsetTracker) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.partitions = make(map[TopicPartition]*partitionOffsets)
+}
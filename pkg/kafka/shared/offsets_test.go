@@ -0,0 +1,64 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOffsetTrackerOutOfOrderAck(t *testing.T) {
+	tracker := NewOffsetTracker()
+	tp := TopicPartition{Topic: "topic", Partition: 1}
+	messages := make([]*KafkaMessage, 0, 5)
+	for offset := int64(10); offset < 15; offset++ {
+		message := &KafkaMessage{Topic: tp.Topic, Partition: tp.Partition, Offset: offset}
+		tracker.Track(message)
+		messages = append(messages, message)
+	}
+
+	assert.True(t, tracker.Ack(messages[3]))
+	assert.True(t, tracker.Ack(messages[1]))
+	_, ok := tracker.Committable()[tp]
+	assert.False(t, ok, "nothing is committable while offset 10 is in flight")
+	assert.Equal(t, OffsetStats{Committable: -1, Blocking: 10, InFlight: 3, AckedAhead: 2}, tracker.Stats()[tp])
+
+	assert.True(t, tracker.Ack(messages[0]))
+	assert.Equal(t, int64(12), tracker.Committable()[tp])
+	assert.Equal(t, OffsetStats{Committable: 12, Blocking: 12, InFlight: 2, AckedAhead: 1}, tracker.Stats()[tp])
+
+	assert.True(t, tracker.Ack(messages[2]))
+	assert.True(t, tracker.Ack(messages[4]))
+	assert.Equal(t, int64(15), tracker.Committable()[tp])
+	assert.Equal(t, OffsetStats{Committable: 15, Blocking: -1}, tracker.Stats()[tp])
+}
+
+func TestOffsetTrackerIgnoresUntracked(t *testing.T) {
+	tracker := NewOffsetTracker()
+	message := &KafkaMessage{Topic: "topic", Partition: 0, Offset: 3}
+	tracker.Track(message)
+	tracker.Reset()
+
+	assert.False(t, tracker.Ack(message))
+	assert.Empty(t, tracker.Committable())
+
+	// A rewind, for example after a rebalance, starts over.
+	tracker.Track(&KafkaMessage{Topic: "topic", Partition: 0, Offset: 5})
+	tracker.Track(message)
+	assert.True(t, tracker.Ack(message))
+	assert.Equal(t, int64(4), tracker.Committable()[TopicPartition{Topic: "topic"}])
+}
+
+func TestOffsetTrackerRejectsOffsetsThatWereNotTracked(t *testing.T) {
+	tracker := NewOffsetTracker()
+	tracker.Track(&KafkaMessage{Topic: "topic", Offset: 10})
+	tracker.Track(&KafkaMessage{Topic: "topic", Offset: 12})
+
+	assert.False(t, tracker.Ack(&KafkaMessage{Topic: "topic", Offset: 11}), "offset 11 was never delivered")
+	assert.Equal(t, OffsetStats{Committable: -1, Blocking: 10, InFlight: 2}, tracker.Stats()[TopicPartition{Topic: "topic"}])
+
+	assert.True(t, tracker.Ack(&KafkaMessage{Topic: "topic", Offset: 10}))
+	offset, ok := tracker.CommittableOffset("topic", 0)
+	assert.True(t, ok)
+	assert.Equal(t, int64(11), offset)
+	_, ok = tracker.CommittableOffset("topic", 1)
+	assert.False(t, ok)
+}
@@ -0,0 +1,162 @@
+package shared
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans of this module.
+const instrumentationName = "github.com/united-manufacturing-hub/Sarama-Kafka-Wrapper-2"
+
+// defaultPropagator propagates W3C traceparent and tracestate headers.
+var defaultPropagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// HeaderCarrier adapts KafkaMessage headers to a propagation.TextMapCarrier.
+type HeaderCarrier map[string]string
+
+// Get returns the value of key.
+func (h HeaderCarrier) Get(key string) string {
+	return h[key]
+}
+
+// Set sets key to value.
+func (h HeaderCarrier) Set(key string, value string) {
+	h[key] = value
+}
+
+// Keys lists all header keys.
+func (h HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// WithTracerProvider makes producers and consumers create their spans with provider, it defaults to the global provider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *Config) error {
+		c.TracerProvider = provider
+		return nil
+	}
+}
+
+// WithPropagator sets how span contexts are written to and read from message headers,
+// it defaults to W3C trace context (traceparent and tracestate).
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(c *Config) error {
+		c.Propagator = propagator
+		return nil
+	}
+}
+
+// extractContext returns a context with the span context propagated in headers, or nil if there is none.
+func extractContext(propagator propagation.TextMapPropagator, headers map[string]string) context.Context {
+	if len(headers) == 0 {
+		return nil
+	}
+	ctx := propagator.Extract(context.Background(), HeaderCarrier(headers))
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	return ctx
+}
+
+// injectContext writes the span context of message.Context to its headers, keeping x-trace and x-origin.
+func injectContext(propagator propagation.TextMapPropagator, message *KafkaMessage) {
+	if message.Context == nil || !trace.SpanContextFromContext(message.Context).IsValid() {
+		return
+	}
+	if message.Headers == nil {
+		message.Headers = make(map[string]string)
+	}
+	propagator.Inject(message.Context, HeaderCarrier(message.Headers))
+}
+
+func (c *Config) tracer() trace.Tracer {
+	return c.TracerProvider.Tracer(instrumentationName)
+}
+
+// messageAttributes returns the messaging semantic convention attributes of message.
+func messageAttributes(message *KafkaMessage, operation attribute.KeyValue) []attribute.KeyValue {
+	attributes := []attribute.KeyValue{
+		semconv.MessagingSystem("kafka"),
+		operation,
+		semconv.MessagingDestinationName(message.Topic),
+		semconv.MessagingMessagePayloadSizeBytes(len(message.Value)),
+	}
+	if len(message.Key) > 0 {
+		attributes = append(attributes, semconv.MessagingKafkaMessageKey(string(message.Key)))
+	}
+	return attributes
+}
+
+// StartProducerSpan starts a publish span for message and returns a context carrying it, which is set as the
+// Context of the produced message so the span is propagated in its headers. The parent is the span of ctx or,
+// if ctx has none, the span the message was consumed with. The caller ends the span with EndProducerSpan.
+func (c *Config) StartProducerSpan(ctx context.Context, message *KafkaMessage) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() && message.Context != nil {
+		ctx = message.Context
+	}
+	return c.tracer().Start(ctx, message.Topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messageAttributes(message, semconv.MessagingOperationPublish)...))
+}
+
+// EndProducerSpan records the partition and offset of a produced message, or err, and ends span.
+func EndProducerSpan(span trace.Span, partition int32, offset int64, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(
+			semconv.MessagingKafkaDestinationPartition(int(partition)),
+			semconv.MessagingKafkaMessageOffset(int(offset)))
+	}
+	span.End()
+}
+
+// startReceiveSpan records the receipt of message as a child of the propagated span context
+// and sets message.Context to it, so processing spans continue the trace.
+func (c *Config) startReceiveSpan(message *KafkaMessage) {
+	ctx := message.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	attributes := append(messageAttributes(message, semconv.MessagingOperationReceive),
+		semconv.MessagingKafkaDestinationPartition(int(message.Partition)),
+		semconv.MessagingKafkaMessageOffset(int(message.Offset)))
+	ctx, span := c.tracer().Start(ctx, message.Topic+" receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attributes...))
+	span.End()
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		message.Context = ctx
+	}
+}
+
+// TraceHandler wraps handler in a process span, which continues the trace of the consumed message.
+func (c *Config) TraceHandler(handler Handler) Handler {
+	return func(ctx context.Context, message *KafkaMessage) error {
+		if message.Context != nil {
+			ctx = trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(message.Context))
+		}
+		attributes := append(messageAttributes(message, semconv.MessagingOperationProcess),
+			semconv.MessagingKafkaDestinationPartition(int(message.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(message.Offset)))
+		ctx, span := c.tracer().Start(ctx, message.Topic+" process",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(attributes...))
+		defer span.End()
+		err := handler(ctx, message)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
@@ -0,0 +1,55 @@
+package shared
+
+import (
+	"context"
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+func TestTraceContextPropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	config, err := NewConfig(Config{}, WithTracerProvider(provider))
+	assert.NoError(t, err)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	parent.End()
+	produced, err := config.ToProducerMessage(&KafkaMessage{Topic: "umh.v1.trace", Value: []byte("v"), Context: ctx})
+	assert.NoError(t, err)
+
+	consumed := &sarama.ConsumerMessage{Topic: produced.Topic, Partition: 2, Offset: 7}
+	headers := make(map[string]string)
+	for _, header := range produced.Headers {
+		consumed.Headers = append(consumed.Headers, &sarama.RecordHeader{Key: header.Key, Value: header.Value})
+		headers[string(header.Key)] = string(header.Value)
+	}
+	assert.Contains(t, headers, "traceparent")
+	assert.Contains(t, headers, "x-trace", "the legacy trace header is kept")
+	assert.Contains(t, headers, "x-origin", "the legacy origin header is kept")
+
+	message := config.FromConsumerMessage(consumed)
+	assert.NotNil(t, message.Context)
+	assert.Equal(t, parent.SpanContext().TraceID(), trace.SpanContextFromContext(message.Context).TraceID())
+
+	handler := config.TraceHandler(func(ctx context.Context, message *KafkaMessage) error {
+		assert.Equal(t, parent.SpanContext().TraceID(), trace.SpanContextFromContext(ctx).TraceID())
+		return nil
+	})
+	assert.NoError(t, handler(context.Background(), message))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	receive, process := spans[1], spans[2]
+	assert.Equal(t, "umh.v1.trace receive", receive.Name())
+	assert.Equal(t, trace.SpanKindConsumer, receive.SpanKind())
+	assert.Equal(t, parent.SpanContext().SpanID(), receive.Parent().SpanID())
+	assert.Equal(t, "umh.v1.trace process", process.Name())
+	assert.Equal(t, receive.SpanContext().SpanID(), process.Parent().SpanID())
+
+	untraced := FromConsumerMessage(&sarama.ConsumerMessage{Topic: "umh.v1.trace"})
+	assert.Nil(t, untraced.Context)
+}
@@ -0,0 +1,200 @@
+package shared
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"math/rand"
+)
+
+// HeaderPartitionFunc selects the partition of a message from its headers.
+// It must return a partition in [0, numPartitions).
+type HeaderPartitionFunc func(headers map[string]string, numPartitions int32) (int32, error)
+
+// WithPartitioner sets the partitioner used by the producer.
+func WithPartitioner(partitioner sarama.PartitionerConstructor) Option {
+	return func(c *Config) error {
+		if partitioner == nil {
+			return errors.New("partitioner must not be nil")
+		}
+		c.Sarama.Producer.Partitioner = partitioner
+		return nil
+	}
+}
+
+// WithExplicitPartitioner makes the producer write every message to KafkaMessage.Partition.
+func WithExplicitPartitioner() Option {
+	return WithPartitioner(sarama.NewManualPartitioner)
+}
+
+// WithMurmur2Partitioner makes the producer place keyed messages like the Java client's default partitioner,
+// so Go and Java producers agree on the partition of a key. Messages with an empty key are spread randomly.
+func WithMurmur2Partitioner() Option {
+	return WithPartitioner(NewMurmur2Partitioner)
+}
+
+// WithRoundRobinPartitioner makes the producer write to all partitions in turn, ignoring the key.
+func WithRoundRobinPartitioner() Option {
+	return WithPartitioner(sarama.NewRoundRobinPartitioner)
+}
+
+// WithStickyPartitioner makes the producer write batchSize messages to the same partition before switching
+// to another random one, which leads to fewer but larger batches. The key is ignored.
+func WithStickyPartitioner(batchSize int) Option {
+	return func(c *Config) error {
+		if batchSize <= 0 {
+			return fmt.Errorf("invalid sticky batch size %d", batchSize)
+		}
+		c.Sarama.Producer.Partitioner = NewStickyPartitioner(batchSize)
+		return nil
+	}
+}
+
+// WithHeaderPartitioner makes the producer select the partition of every message with partition.
+func WithHeaderPartitioner(partition HeaderPartitionFunc) Option {
+	return func(c *Config) error {
+		if partition == nil {
+			return errors.New("partition function must not be nil")
+		}
+		c.Sarama.Producer.Partitioner = NewHeaderPartitioner(partition)
+		return nil
+	}
+}
+
+// murmur2Partitioner is a sarama.Partitioner compatible with org.apache.kafka.clients.producer.internals.DefaultPartitioner.
+type murmur2Partitioner struct {
+	random sarama.Partitioner
+}
+
+// NewMurmur2Partitioner returns a partitioner placing keyed messages at toPositive(murmur2(key)) % numPartitions,
+// like the Java client does.
+func NewMurmur2Partitioner(topic string) sarama.Partitioner {
+	return &murmur2Partitioner{random: sarama.NewRandomPartitioner(topic)}
+}
+
+func (p *murmur2Partitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if !hasKey(message) {
+		return p.random.Partition(message, numPartitions)
+	}
+	key, err := message.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+	return (Murmur2(key) & 0x7fffffff) % numPartitions, nil
+}
+
+func (p *murmur2Partitioner) RequiresConsistency() bool {
+	return true
+}
+
+func (p *murmur2Partitioner) MessageRequiresConsistency(message *sarama.ProducerMessage) bool {
+	return hasKey(message)
+}
+
+// hasKey returns whether message has a non-empty key, ToProducerMessage encodes a missing key as an empty one.
+func hasKey(message *sarama.ProducerMessage) bool {
+	return message.Key != nil && message.Key.Length() > 0
+}
+
+// Murmur2 returns the 32-bit murmur2 hash of data as computed by the Java client's Utils.murmur2.
+func Murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// stickyPartitioner writes batchSize messages to one partition before switching.
+// sarama creates one partitioner per topic and calls it from a single goroutine, so it needs no locking.
+type stickyPartitioner struct {
+	batchSize int
+	partition int32
+	written   int
+}
+
+// NewStickyPartitioner returns a constructor for partitioners writing batchSize messages to the same partition
+// before switching to another random one.
+func NewStickyPartitioner(batchSize int) sarama.PartitionerConstructor {
+	return func(_ string) sarama.Partitioner {
+		return &stickyPartitioner{batchSize: batchSize, partition: -1}
+	}
+}
+
+func (p *stickyPartitioner) Partition(_ *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if p.partition < 0 || p.partition >= numPartitions || p.written >= p.batchSize {
+		next := rand.Int31n(numPartitions) //nolint:gosec
+		if next == p.partition && numPartitions > 1 {
+			next = (next + 1) % numPartitions
+		}
+		p.partition = next
+		p.written = 0
+	}
+	p.written++
+	return p.partition, nil
+}
+
+func (p *stickyPartitioner) RequiresConsistency() bool {
+	return false
+}
+
+// headerPartitioner delegates the partition selection to a HeaderPartitionFunc.
+type headerPartitioner struct {
+	partition HeaderPartitionFunc
+}
+
+// NewHeaderPartitioner returns a constructor for partitioners selecting the partition with partition.
+func NewHeaderPartitioner(partition HeaderPartitionFunc) sarama.PartitionerConstructor {
+	return func(_ string) sarama.Partitioner {
+		return &headerPartitioner{partition: partition}
+	}
+}
+
+func (p *headerPartitioner) Partition(message *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	headers := make(map[string]string, len(message.Headers))
+	for _, header := range message.Headers {
+		headers[string(header.Key)] = string(header.Value)
+	}
+	partition, err := p.partition(headers, numPartitions)
+	if err != nil {
+		return -1, err
+	}
+	if partition < 0 || partition >= numPartitions {
+		return -1, sarama.ErrInvalidPartition
+	}
+	return partition, nil
+}
+
+func (p *headerPartitioner) RequiresConsistency() bool {
+	return true
+}
@@ -0,0 +1,58 @@
+package shared
+
+import (
+	"errors"
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMurmur2MatchesJavaClient(t *testing.T) {
+	// Test vectors from org.apache.kafka.common.utils.UtilsTest.
+	cases := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for key, expected := range cases {
+		assert.Equal(t, expected, Murmur2([]byte(key)), key)
+	}
+}
+
+func TestPartitioners(t *testing.T) {
+	murmur2 := NewMurmur2Partitioner("topic")
+	partition, err := murmur2.Partition(ToProducerMessage(&KafkaMessage{Topic: "topic", Key: []byte("foobar")}), 10)
+	assert.NoError(t, err)
+	// (-790332482 & 0x7fffffff) % 10
+	assert.Equal(t, int32(6), partition)
+
+	explicit := sarama.NewManualPartitioner("topic")
+	partition, err = explicit.Partition(ToProducerMessage(&KafkaMessage{Topic: "topic", Partition: 3}), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), partition)
+
+	sticky := NewStickyPartitioner(3)("topic")
+	partitions := make([]int32, 6)
+	for i := range partitions {
+		partitions[i], err = sticky.Partition(&sarama.ProducerMessage{}, 4)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, partitions[0], partitions[2])
+	assert.Equal(t, partitions[3], partitions[5])
+	assert.NotEqual(t, partitions[2], partitions[3])
+
+	header := NewHeaderPartitioner(func(headers map[string]string, numPartitions int32) (int32, error) {
+		if headers["site"] == "" {
+			return 0, errors.New("missing site")
+		}
+		return int32(len(headers["site"])) % numPartitions, nil
+	})("topic")
+	partition, err = header.Partition(ToProducerMessage(&KafkaMessage{Topic: "topic", Headers: map[string]string{"site": "aachen"}}), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), partition)
+	_, err = header.Partition(&sarama.ProducerMessage{}, 4)
+	assert.Error(t, err)
+}
@@ -0,0 +1,152 @@
+package shared
+
+import (
+	"sort"
+	"sync"
+)
+
+// Pausable is the part of sarama.ConsumerGroup the Pauser needs.
+type Pausable interface {
+	Pause(partitions map[string][]int32)
+	Resume(partitions map[string][]int32)
+}
+
+// Pauser keeps track of paused topics and partitions and applies them to the partitions of the current session.
+// sarama forgets pauses when partitions are reassigned, so the consumers apply them again whenever a claim starts.
+// Messages that were fetched before a pause are still delivered.
+type Pauser struct {
+	mutex      sync.Mutex
+	all        bool
+	topics     map[string]struct{}
+	partitions map[TopicPartition]struct{}
+	// claims are the partitions of the current session.
+	claims map[string][]int32
+}
+
+// NewPauser returns a Pauser with nothing paused.
+func NewPauser() *Pauser {
+	return &Pauser{
+		topics:     make(map[string]struct{}),
+		partitions: make(map[TopicPartition]struct{}),
+	}
+}
+
+// Pause pauses the given partitions, an empty partition list pauses the whole topic.
+func (p *Pauser) Pause(partitions map[string][]int32) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for topic, list := range partitions {
+		if len(list) == 0 {
+			p.topics[topic] = struct{}{}
+			continue
+		}
+		for _, partition := range list {
+			p.partitions[TopicPartition{Topic: topic, Partition: partition}] = struct{}{}
+		}
+	}
+}
+
+// Resume lifts pauses of the given partitions, an empty partition list lifts all pauses of the topic.
+// Resuming a single partition does not lift a pause of its whole topic or of PauseAll.
+func (p *Pauser) Resume(partitions map[string][]int32) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for topic, list := range partitions {
+		if len(list) == 0 {
+			delete(p.topics, topic)
+			for key := range p.partitions {
+				if key.Topic == topic {
+					delete(p.partitions, key)
+				}
+			}
+			continue
+		}
+		for _, partition := range list {
+			delete(p.partitions, TopicPartition{Topic: topic, Partition: partition})
+		}
+	}
+}
+
+// PauseAll pauses all partitions, including partitions assigned later.
+func (p *Pauser) PauseAll() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.all = true
+}
+
+// ResumeAll lifts all pauses.
+func (p *Pauser) ResumeAll() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.all = false
+	p.topics = make(map[string]struct{})
+	p.partitions = make(map[TopicPartition]struct{})
+}
+
+// IsPaused returns whether the partition is paused.
+func (p *Pauser) IsPaused(topic string, partition int32) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.isPaused(topic, partition)
+}
+
+func (p *Pauser) isPaused(topic string, partition int32) bool {
+	if p.all {
+		return true
+	}
+	if _, ok := p.topics[topic]; ok {
+		return true
+	}
+	_, ok := p.partitions[TopicPartition{Topic: topic, Partition: partition}]
+	return ok
+}
+
+// Paused returns the paused partitions of the current session.
+func (p *Pauser) Paused() map[string][]int32 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	paused, _ := p.split()
+	return paused
+}
+
+// SetClaims sets the partitions of the current session, it is called from Setup and with nil from Cleanup.
+func (p *Pauser) SetClaims(claims map[string][]int32) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.claims = claims
+}
+
+// Sync pauses and resumes the partitions of the current session on group according to the pause state.
+func (p *Pauser) Sync(group Pausable) {
+	if group == nil {
+		return
+	}
+	p.mutex.Lock()
+	paused, running := p.split()
+	p.mutex.Unlock()
+	if len(paused) > 0 {
+		group.Pause(paused)
+	}
+	if len(running) > 0 {
+		group.Resume(running)
+	}
+}
+
+// split divides the claims into paused and running partitions.
+func (p *Pauser) split() (map[string][]int32, map[string][]int32) {
+	paused := make(map[string][]int32)
+	running := make(map[string][]int32)
+	for topic, partitions := range p.claims {
+		for _, partition := range partitions {
+			if p.isPaused(topic, partition) {
+				paused[topic] = append(paused[topic], partition)
+			} else {
+				running[topic] = append(running[topic], partition)
+			}
+		}
+	}
+	for _, partitions := range paused {
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	}
+	return paused, running
+}
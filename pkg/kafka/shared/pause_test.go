@@ -0,0 +1,46 @@
+package shared
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type recordingGroup struct {
+	paused  map[string][]int32
+	resumed map[string][]int32
+}
+
+func (r *recordingGroup) Pause(partitions map[string][]int32) {
+	r.paused = partitions
+}
+
+func (r *recordingGroup) Resume(partitions map[string][]int32) {
+	r.resumed = partitions
+}
+
+func TestPauserSync(t *testing.T) {
+	pauser := NewPauser()
+	pauser.Pause(map[string][]int32{"a": {1}, "b": nil})
+	pauser.SetClaims(map[string][]int32{"a": {0, 1}, "b": {0}})
+
+	group := &recordingGroup{}
+	pauser.Sync(group)
+	assert.Equal(t, map[string][]int32{"a": {1}, "b": {0}}, group.paused)
+	assert.Equal(t, map[string][]int32{"a": {0}}, group.resumed)
+	assert.Equal(t, map[string][]int32{"a": {1}, "b": {0}}, pauser.Paused())
+
+	pauser.Resume(map[string][]int32{"b": nil})
+	assert.False(t, pauser.IsPaused("b", 0))
+	assert.True(t, pauser.IsPaused("a", 1))
+
+	pauser.PauseAll()
+	assert.True(t, pauser.IsPaused("c", 7), "PauseAll also pauses partitions assigned later")
+	pauser.Resume(map[string][]int32{"a": {1}})
+	assert.True(t, pauser.IsPaused("a", 1), "resuming a partition does not lift PauseAll")
+
+	pauser.ResumeAll()
+	group = &recordingGroup{}
+	pauser.Sync(group)
+	assert.Nil(t, group.paused)
+	assert.Equal(t, map[string][]int32{"a": {0, 1}, "b": {0}}, group.resumed)
+}
@@ -0,0 +1,111 @@
+package shared
+
+import (
+	"errors"
+	"github.com/IBM/sarama"
+)
+
+// Assignment describes the partitions of a consumer group session.
+type Assignment struct {
+	// Claims maps the claimed topics to their partitions.
+	Claims map[string][]int32
+	// GenerationID is the generation of the consumer group the session belongs to.
+	GenerationID int32
+	// MemberID is the ID the broker assigned to the consumer for this generation.
+	MemberID string
+}
+
+// RebalanceListener is notified when partitions are assigned to or taken away from a consumer.
+// The callbacks are called synchronously from the consumer group session, consumption does not start
+// before OnPartitionsAssigned returned and the next session does not start before OnPartitionsRevoked returned.
+// They must return well within the rebalance timeout. Nil callbacks are skipped.
+type RebalanceListener struct {
+	// OnPartitionsAssigned is called when a session starts, before any message of it is delivered.
+	OnPartitionsAssigned func(assignment Assignment)
+	// OnPartitionsRevoked is called when a session ends and its partitions are handed over in an orderly rebalance.
+	// Messages marked before the callback returns are still committed.
+	OnPartitionsRevoked func(assignment Assignment)
+	// OnPartitionsLost is called instead of OnPartitionsRevoked if the consumer was removed from the group,
+	// for example because it missed its session timeout or was fenced. The partitions might already be consumed
+	// by another member and commits of the session fail, so per-partition state has to be discarded instead of flushed.
+	// If it is nil, OnPartitionsRevoked is called instead.
+	OnPartitionsLost func(assignment Assignment)
+}
+
+// WithRebalanceListener makes consumers notify listener about assigned, revoked and lost partitions.
+func WithRebalanceListener(listener RebalanceListener) Option {
+	return func(c *Config) error {
+		c.RebalanceListener = &listener
+		return nil
+	}
+}
+
+// NewAssignment returns the Assignment of session.
+func NewAssignment(session sarama.ConsumerGroupSession) Assignment {
+	return Assignment{
+		Claims:       session.Claims(),
+		GenerationID: session.GenerationID(),
+		MemberID:     session.MemberID(),
+	}
+}
+
+// NotifyAssigned calls OnPartitionsAssigned of the configured listener, if any.
+func (c *Config) NotifyAssigned(session sarama.ConsumerGroupSession) {
+	if c.RebalanceListener == nil || c.RebalanceListener.OnPartitionsAssigned == nil {
+		return
+	}
+	c.RebalanceListener.OnPartitionsAssigned(NewAssignment(session))
+}
+
+// NotifyRevoked calls OnPartitionsRevoked or OnPartitionsLost of the configured listener, if any,
+// and returns whether the partitions were lost.
+// To tell them apart, it asks the group coordinator whether the member of session still belongs to the group.
+func (c *Config) NotifyRevoked(client sarama.Client, group string, session sarama.ConsumerGroupSession) bool {
+	listener := c.RebalanceListener
+	if listener == nil {
+		return false
+	}
+	assignment := NewAssignment(session)
+	if listener.OnPartitionsLost != nil && c.sessionLost(client, group, session) {
+		listener.OnPartitionsLost(assignment)
+		return true
+	}
+	if listener.OnPartitionsRevoked != nil {
+		listener.OnPartitionsRevoked(assignment)
+	}
+	return false
+}
+
+// sessionLost sends a heartbeat for session and returns whether the coordinator no longer knows its member or generation.
+// Errors reaching the coordinator are not treated as a lost session.
+func (c *Config) sessionLost(client sarama.Client, group string, session sarama.ConsumerGroupSession) bool {
+	if client == nil || client.Closed() {
+		return false
+	}
+	log := c.Logger.Sugar().With("group_id", group, "member_id", session.MemberID(), "generation", session.GenerationID())
+	coordinator, err := client.Coordinator(group)
+	if err != nil {
+		log.Debugf("Failed to get coordinator of group %s: %s", group, err)
+		return false
+	}
+	request := &sarama.HeartbeatRequest{
+		GroupId:      group,
+		MemberId:     session.MemberID(),
+		GenerationId: session.GenerationID(),
+	}
+	if c.Sarama.Version.IsAtLeast(sarama.V2_3_0_0) {
+		request.Version = 3
+		if c.Sarama.Consumer.Group.InstanceId != "" {
+			instanceID := c.Sarama.Consumer.Group.InstanceId
+			request.GroupInstanceId = &instanceID
+		}
+	}
+	response, err := coordinator.Heartbeat(request)
+	if err != nil {
+		log.Debugf("Failed to send heartbeat to group %s: %s", group, err)
+		return false
+	}
+	return errors.Is(response.Err, sarama.ErrUnknownMemberId) ||
+		errors.Is(response.Err, sarama.ErrIllegalGeneration) ||
+		errors.Is(response.Err, sarama.ErrFencedInstancedId)
+}
@@ -0,0 +1,151 @@
+package shared
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// scramClient implements the client side of the SCRAM authentication exchange (RFC 5802) for sarama.
+type scramClient struct {
+	hashGenerator func() hash.Hash
+
+	user     string
+	password string
+	authzID  string
+	// nonce is the client nonce, it is generated in Begin unless already set.
+	nonce string
+
+	step            int
+	gs2Header       string
+	clientFirstBare string
+	serverSignature []byte
+	done            bool
+}
+
+// Begin prepares the client for a new authentication exchange.
+func (s *scramClient) Begin(userName, password, authzID string) error {
+	s.user = userName
+	s.password = password
+	s.authzID = authzID
+	s.step = 0
+	s.done = false
+	if s.nonce == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		s.nonce = base64.RawStdEncoding.EncodeToString(b)
+	}
+	return nil
+}
+
+// Step takes the server challenge and returns the next client message.
+func (s *scramClient) Step(challenge string) (string, error) {
+	s.step++
+	switch s.step {
+	case 1:
+		return s.clientFirst(), nil
+	case 2:
+		return s.clientFinal(challenge)
+	case 3:
+		return "", s.verifyServerFinal(challenge)
+	default:
+		return "", errors.New("scram: unexpected challenge after authentication finished")
+	}
+}
+
+// Done returns true once the server signature has been verified.
+func (s *scramClient) Done() bool {
+	return s.done
+}
+
+func (s *scramClient) clientFirst() string {
+	s.gs2Header = "n,,"
+	if s.authzID != "" {
+		s.gs2Header = "n,a=" + scramEscape(s.authzID) + ","
+	}
+	s.clientFirstBare = "n=" + scramEscape(s.user) + ",r=" + s.nonce
+	return s.gs2Header + s.clientFirstBare
+}
+
+func (s *scramClient) clientFinal(serverFirst string) (string, error) {
+	attributes := scramAttributes(serverFirst)
+	serverNonce := attributes["r"]
+	if !strings.HasPrefix(serverNonce, s.nonce) || len(serverNonce) == len(s.nonce) {
+		return "", errors.New("scram: invalid server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attributes["s"])
+	if err != nil {
+		return "", fmt.Errorf("scram: invalid salt: %w", err)
+	}
+	iterations, err := strconv.Atoi(attributes["i"])
+	if err != nil || iterations <= 0 {
+		return "", errors.New("scram: invalid iteration count")
+	}
+
+	saltedPassword := pbkdf2.Key([]byte(s.password), salt, iterations, s.hashGenerator().Size(), s.hashGenerator)
+	clientKey := s.hmac(saltedPassword, []byte("Client Key"))
+	h := s.hashGenerator()
+	h.Write(clientKey)
+	storedKey := h.Sum(nil)
+
+	clientFinalWithoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte(s.gs2Header)) + ",r=" + serverNonce
+	authMessage := []byte(s.clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof)
+
+	clientSignature := s.hmac(storedKey, authMessage)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSignature[i]
+	}
+	serverKey := s.hmac(saltedPassword, []byte("Server Key"))
+	s.serverSignature = s.hmac(serverKey, authMessage)
+
+	return clientFinalWithoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (s *scramClient) verifyServerFinal(serverFinal string) error {
+	attributes := scramAttributes(serverFinal)
+	if e, ok := attributes["e"]; ok {
+		return fmt.Errorf("scram: server rejected authentication: %s", e)
+	}
+	verifier, err := base64.StdEncoding.DecodeString(attributes["v"])
+	if err != nil {
+		return fmt.Errorf("scram: invalid server signature: %w", err)
+	}
+	if subtle.ConstantTimeCompare(verifier, s.serverSignature) != 1 {
+		return errors.New("scram: server signature mismatch")
+	}
+	s.done = true
+	return nil
+}
+
+func (s *scramClient) hmac(key, data []byte) []byte {
+	mac := hmac.New(s.hashGenerator, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// scramAttributes parses a comma separated list of key=value attributes.
+func scramAttributes(message string) map[string]string {
+	attributes := make(map[string]string)
+	for _, field := range strings.Split(message, ",") {
+		key, value, found := strings.Cut(field, "=")
+		if found {
+			attributes[key] = value
+		}
+	}
+	return attributes
+}
+
+// scramEscape escapes user and authorization names as described in RFC 5802 section 5.1.
+func scramEscape(s string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s)
+}
@@ -0,0 +1,48 @@
+package shared
+
+import (
+	"crypto/sha256"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// TestSCRAMSHA256 replays the example exchange of RFC 7677 section 3.
+func TestSCRAMSHA256(t *testing.T) {
+	client := &scramClient{hashGenerator: sha256.New, nonce: "rOprNGfwEbeRWgbNEkqO"}
+	assert.NoError(t, client.Begin("user", "pencil", ""))
+
+	clientFirst, err := client.Step("")
+	assert.NoError(t, err)
+	assert.Equal(t, "n,,n=user,r=rOprNGfwEbeRWgbNEkqO", clientFirst)
+
+	clientFinal, err := client.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	assert.NoError(t, err)
+	assert.Equal(t, "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=", clientFinal)
+	assert.False(t, client.Done())
+
+	_, err = client.Step("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")
+	assert.NoError(t, err)
+	assert.True(t, client.Done())
+}
+
+func TestSCRAMRejectsInvalidServerSignature(t *testing.T) {
+	client := &scramClient{hashGenerator: sha256.New, nonce: "rOprNGfwEbeRWgbNEkqO"}
+	assert.NoError(t, client.Begin("user", "pencil", ""))
+	_, err := client.Step("")
+	assert.NoError(t, err)
+	_, err = client.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	assert.NoError(t, err)
+
+	_, err = client.Step("v=AAAATRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")
+	assert.Error(t, err)
+	assert.False(t, client.Done())
+}
+
+func TestSCRAMRejectsForeignNonce(t *testing.T) {
+	client := &scramClient{hashGenerator: sha256.New, nonce: "rOprNGfwEbeRWgbNEkqO"}
+	assert.NoError(t, client.Begin("user", "pencil", ""))
+	_, err := client.Step("")
+	assert.NoError(t, err)
+	_, err = client.Step("r=somethingElse,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	assert.Error(t, err)
+}
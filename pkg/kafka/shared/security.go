@@ -0,0 +1,103 @@
+package shared
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/IBM/sarama"
+	"os"
+)
+
+// WithTLS enables TLS using the given configuration.
+func WithTLS(tlsConfig *tls.Config) Option {
+	return func(c *Config) error {
+		if tlsConfig == nil {
+			return errors.New("tls config must not be nil")
+		}
+		c.Sarama.Net.TLS.Enable = true
+		c.Sarama.Net.TLS.Config = tlsConfig
+		return nil
+	}
+}
+
+// WithTLSFromFiles enables TLS using PEM encoded certificates read from disk.
+// caFile may be empty to use the system roots, certFile and keyFile may be empty if no client certificate is required.
+func WithTLSFromFiles(caFile, certFile, keyFile string) Option {
+	return func(c *Config) error {
+		var caPEM, certPEM, keyPEM []byte
+		var err error
+		if caFile != "" {
+			caPEM, err = os.ReadFile(caFile)
+			if err != nil {
+				return fmt.Errorf("failed to read CA file: %w", err)
+			}
+		}
+		if certFile != "" {
+			certPEM, err = os.ReadFile(certFile)
+			if err != nil {
+				return fmt.Errorf("failed to read certificate file: %w", err)
+			}
+		}
+		if keyFile != "" {
+			keyPEM, err = os.ReadFile(keyFile)
+			if err != nil {
+				return fmt.Errorf("failed to read key file: %w", err)
+			}
+		}
+		return WithTLSFromPEM(caPEM, certPEM, keyPEM)(c)
+	}
+}
+
+// WithTLSFromPEM enables TLS using PEM encoded certificates.
+// caPEM may be empty to use the system roots, certPEM and keyPEM may be empty if no client certificate is required.
+func WithTLSFromPEM(caPEM, certPEM, keyPEM []byte) Option {
+	return func(c *Config) error {
+		tlsConfig := &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+		if len(caPEM) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return errors.New("failed to parse CA certificate")
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if len(certPEM) > 0 || len(keyPEM) > 0 {
+			certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return fmt.Errorf("failed to parse client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{certificate}
+		}
+		return WithTLS(tlsConfig)(c)
+	}
+}
+
+// WithSASL enables SASL authentication.
+// Supported mechanisms are sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256 and sarama.SASLTypeSCRAMSHA512.
+func WithSASL(mechanism sarama.SASLMechanism, user, password string) Option {
+	return func(c *Config) error {
+		switch mechanism {
+		case sarama.SASLTypePlaintext:
+		case sarama.SASLTypeSCRAMSHA256:
+			c.Sarama.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hashGenerator: sha256.New}
+			}
+		case sarama.SASLTypeSCRAMSHA512:
+			c.Sarama.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hashGenerator: sha512.New}
+			}
+		default:
+			return fmt.Errorf("unsupported SASL mechanism %s", mechanism)
+		}
+		c.Sarama.Net.SASL.Enable = true
+		c.Sarama.Net.SASL.Handshake = true
+		c.Sarama.Net.SASL.Mechanism = mechanism
+		c.Sarama.Net.SASL.User = user
+		c.Sarama.Net.SASL.Password = password
+		return nil
+	}
+}
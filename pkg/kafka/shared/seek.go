@@ -0,0 +1,150 @@
+package shared
+
+import (
+	"github.com/IBM/sarama"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// seek is a pending reposition of a partition.
+type seek struct {
+	// offset is an absolute offset, sarama.OffsetOldest or sarama.OffsetNewest.
+	offset int64
+	// timestamp is used instead of offset if it is not zero.
+	timestamp time.Time
+}
+
+// Seeker collects seeks and applies them when partitions are assigned.
+// Requesting a seek ends the current session, so it is applied with the next assignment right away.
+type Seeker struct {
+	mutex      sync.Mutex
+	partitions map[TopicPartition]seek
+	// all is applied to every partition of the next assignment without a partition specific seek.
+	all *seek
+	// restart has a value while seeks are pending that the current session has not picked up yet.
+	restart chan struct{}
+}
+
+// NewSeeker returns a Seeker without pending seeks.
+func NewSeeker() *Seeker {
+	return &Seeker{
+		partitions: make(map[TopicPartition]seek),
+		restart:    make(chan struct{}, 1),
+	}
+}
+
+// SeekToOffset makes the consumer continue at offset on the partition.
+func (s *Seeker) SeekToOffset(topic string, partition int32, offset int64) {
+	s.add(TopicPartition{Topic: topic, Partition: partition}, seek{offset: offset})
+}
+
+// SeekToBeginning makes the consumer continue at the oldest retained message of the partition.
+func (s *Seeker) SeekToBeginning(topic string, partition int32) {
+	s.add(TopicPartition{Topic: topic, Partition: partition}, seek{offset: sarama.OffsetOldest})
+}
+
+// SeekToEnd makes the consumer skip all messages currently in the partition.
+func (s *Seeker) SeekToEnd(topic string, partition int32) {
+	s.add(TopicPartition{Topic: topic, Partition: partition}, seek{offset: sarama.OffsetNewest})
+}
+
+// SeekToTimestamp makes the consumer continue at the first message of the partition with a timestamp at or after t.
+// If there is none, it continues at the end of the partition.
+func (s *Seeker) SeekToTimestamp(topic string, partition int32, t time.Time) {
+	s.add(TopicPartition{Topic: topic, Partition: partition}, seek{timestamp: t})
+}
+
+// SeekAllToTimestamp applies SeekToTimestamp to every partition of the next assignment.
+func (s *Seeker) SeekAllToTimestamp(t time.Time) {
+	s.mutex.Lock()
+	s.all = &seek{timestamp: t}
+	s.mutex.Unlock()
+	s.signal()
+}
+
+func (s *Seeker) add(key TopicPartition, target seek) {
+	s.mutex.Lock()
+	s.partitions[key] = target
+	s.mutex.Unlock()
+	s.signal()
+}
+
+// signal requests the end of the current session without blocking.
+func (s *Seeker) signal() {
+	select {
+	case s.restart <- struct{}{}:
+	default:
+	}
+}
+
+// Restart returns a channel that receives a value when seeks are pending, consumers end their session on it.
+func (s *Seeker) Restart() <-chan struct{} {
+	return s.restart
+}
+
+// Apply resets the offsets of all claimed partitions of session that have a pending seek and commits them,
+// it is called from Setup. Seeks for partitions that are not claimed stay pending,
+// seeks that cannot be resolved are retried with the next assignment. It logs the seeks to log.
+func (s *Seeker) Apply(session sarama.ConsumerGroupSession, client sarama.Client, log *zap.SugaredLogger) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// The session picks up all pending seeks now.
+	select {
+	case <-s.restart:
+	default:
+	}
+
+	failed := false
+	applied := false
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			key := TopicPartition{Topic: topic, Partition: partition}
+			target, ok := s.partitions[key]
+			if !ok {
+				if s.all == nil {
+					continue
+				}
+				target = *s.all
+			}
+			offset, err := resolve(client, key, target)
+			if err != nil {
+				log.Warnf("Failed to resolve seek of %s/%d: %s", topic, partition, err)
+				failed = true
+				continue
+			}
+			log.Infof("Seeking %s/%d to offset %d", topic, partition, offset)
+			// ResetOffset only moves backwards and MarkOffset only forwards, together they set any offset.
+			session.ResetOffset(topic, partition, offset, "")
+			session.MarkOffset(topic, partition, offset, "")
+			delete(s.partitions, key)
+			applied = true
+		}
+	}
+	if !failed {
+		s.all = nil
+	}
+	if applied {
+		// Commit right away, so the seek survives a session that ends before anything is marked.
+		session.Commit()
+	}
+}
+
+// resolve returns the absolute offset target refers to.
+func resolve(client sarama.Client, key TopicPartition, target seek) (int64, error) {
+	if target.timestamp.IsZero() {
+		if target.offset >= 0 {
+			return target.offset, nil
+		}
+		return client.GetOffset(key.Topic, key.Partition, target.offset)
+	}
+	offset, err := client.GetOffset(key.Topic, key.Partition, target.timestamp.UnixMilli())
+	if err != nil {
+		return -1, err
+	}
+	if offset < 0 {
+		return client.GetOffset(key.Topic, key.Partition, sarama.OffsetNewest)
+	}
+	return offset, nil
+}
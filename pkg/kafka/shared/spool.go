@@ -0,0 +1,83 @@
+package shared
+
+import (
+	"errors"
+	"time"
+)
+
+// SpoolEviction selects what happens when the spool of the producer is full.
+type SpoolEviction int
+
+const (
+	// SpoolEvictOldest deletes the oldest segments to make room for new messages.
+	SpoolEvictOldest SpoolEviction = iota
+	// SpoolRejectNew keeps the spooled messages and returns an error for new ones.
+	SpoolRejectNew
+)
+
+// SpoolConfig configures the disk spool the producer falls back to while the brokers are unreachable.
+type SpoolConfig struct {
+	// Dir is the directory of the segment files, it is created if it does not exist.
+	// It must not be shared with other producers.
+	Dir string
+	// SegmentSize is the size after which a new segment file is started.
+	SegmentSize int64
+	// MaxSize caps the total size of all segment files.
+	MaxSize int64
+	// Eviction selects what happens once MaxSize is reached.
+	Eviction SpoolEviction
+	// ReplayInterval is how often the producer tries to replay spooled messages.
+	ReplayInterval time.Duration
+	// SyncInterval is how long appended messages may stay unsynced, a negative interval syncs after every message.
+	// SendMessage returns before the sync, so messages spooled within the interval before a power loss are lost.
+	SyncInterval time.Duration
+}
+
+// DefaultSpoolConfig holds up to 1 GiB in 16 MiB segments, evicting the oldest, retries replaying every 5 seconds
+// and syncs appended messages within a second.
+var DefaultSpoolConfig = SpoolConfig{
+	SegmentSize:    16 << 20,
+	MaxSize:        1 << 30,
+	Eviction:       SpoolEvictOldest,
+	ReplayInterval: 5 * time.Second,
+	SyncInterval:   time.Second,
+}
+
+// WithSpool enables the disk spool of the producer, unset fields of config default to DefaultSpoolConfig.
+// SendMessage then writes messages to the spool while the brokers are unreachable or the in-flight limit is reached,
+// and the producer replays them in order once the brokers are back. A message that was sent directly and failed with
+// a retriable error is spooled too, and replayed before the newer messages of its segment. If newer messages were
+// already moved to an older segment, it is replayed after them and a warning is logged.
+// If a replay fails, the unacknowledged messages of the segment are replayed again before any newer ones.
+// Spooled messages are delivered at least once, messages acknowledged before a restart can be replayed again.
+// It cannot be combined with WithTransactionalID.
+func WithSpool(config SpoolConfig) Option {
+	return func(c *Config) error {
+		if config.Dir == "" {
+			return errors.New("spool directory must not be empty")
+		}
+		if config.SegmentSize == 0 {
+			config.SegmentSize = DefaultSpoolConfig.SegmentSize
+		}
+		if config.MaxSize == 0 {
+			config.MaxSize = DefaultSpoolConfig.MaxSize
+		}
+		if config.ReplayInterval == 0 {
+			config.ReplayInterval = DefaultSpoolConfig.ReplayInterval
+		}
+		if config.SyncInterval == 0 {
+			config.SyncInterval = DefaultSpoolConfig.SyncInterval
+		}
+		if config.SegmentSize < 0 || config.MaxSize < config.SegmentSize {
+			return errors.New("spool needs a positive segment size not above the maximum size")
+		}
+		if config.ReplayInterval < 0 {
+			return errors.New("spool replay interval must be positive")
+		}
+		if config.Eviction != SpoolEvictOldest && config.Eviction != SpoolRejectNew {
+			return errors.New("invalid spool eviction policy")
+		}
+		c.Spool = &config
+		return nil
+	}
+}
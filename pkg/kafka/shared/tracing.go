@@ -1,8 +1,14 @@
 package shared
 
 import (
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/united-manufacturing-hub/umh-utils/env"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -64,38 +70,270 @@ func IsInTrace(msg *KafkaMessage) bool {
 	return false
 }
 
+// GetSXTrace decodes the x-trace header of msg, which can be JSON or TraceEncodingBinary encoded.
 func GetSXTrace(msg *KafkaMessage) (bool, TraceValue) {
 	ok, traceS := GetSHeader(msg, "x-trace")
 	var traceValue TraceValue
 	if !ok {
 		return false, traceValue
-	} else {
-		err := json.Unmarshal([]byte(traceS), &traceValue)
-		if err != nil {
-			return false, traceValue
-		}
+	}
+	traceValue, err := decodeTrace(traceS)
+	if err != nil {
+		return false, traceValue
 	}
 	return true, traceValue
 }
 
+// AddSXTrace adds this service to the x-trace header of msg, within the limits of DefaultTraceOptions.
 func AddSXTrace(msg *KafkaMessage) error {
+	return addSXTrace(msg, DefaultTraceOptions)
+}
+
+// AddSXTrace adds this service to the x-trace header of msg, within the configured TraceOptions.
+func (c *Config) AddSXTrace(msg *KafkaMessage) error {
+	return c.Trace.AddSXTrace(msg)
+}
+
+// AddSXTrace adds this service to the x-trace header of msg, within the limits and with the encoding of o.
+func (o TraceOptions) AddSXTrace(msg *KafkaMessage) error {
+	return addSXTrace(msg, o)
+}
+
+func addSXTrace(msg *KafkaMessage, options TraceOptions) error {
 	identifier := microserviceName + "-" + serialNumber
 	ok, trace := GetSXTrace(msg)
-	if !ok {
+	if !ok || trace.Traces == nil {
 		trace = TraceValue{
 			Traces: map[int64]string{},
 		}
-		trace.Traces = make(map[int64]string)
 	}
 
 	t := time.Now().UnixNano()
 	trace.Traces[t] = identifier
 
-	j, err := json.Marshal(trace)
+	encoded, err := options.encode(trace)
 	if err != nil {
 		return err
 	}
-	AddSHeader(msg, "x-trace", string(j))
+	AddSHeader(msg, "x-trace", encoded)
+
+	return nil
+}
 
+// boundSXTrace re-encodes the x-trace header of msg within options, without adding a hop.
+func boundSXTrace(msg *KafkaMessage, options TraceOptions) error {
+	ok, trace := GetSXTrace(msg)
+	if !ok {
+		return nil
+	}
+	encoded, err := options.encode(trace)
+	if err != nil {
+		return err
+	}
+	AddSHeader(msg, "x-trace", encoded)
 	return nil
 }
+
+// TraceHop is a service the message passed through, see KafkaMessage.TraceHops.
+type TraceHop struct {
+	// Service identifies the service by microservice name and serial number.
+	Service string
+	// Time is when the service produced the message.
+	Time time.Time
+	// Duration is the time since the previous hop, it is 0 for the first hop.
+	Duration time.Duration
+}
+
+// TraceHops returns the hops recorded in the x-trace header, oldest first.
+// It returns nil if the message has no valid x-trace header.
+func (m *KafkaMessage) TraceHops() []TraceHop {
+	ok, trace := GetSXTrace(m)
+	if !ok {
+		return nil
+	}
+	timestamps := trace.sorted()
+	hops := make([]TraceHop, 0, len(timestamps))
+	for i, timestamp := range timestamps {
+		hop := TraceHop{
+			Service: trace.Traces[timestamp],
+			Time:    time.Unix(0, timestamp),
+		}
+		if i > 0 {
+			hop.Duration = time.Duration(timestamp - timestamps[i-1])
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// sorted returns the timestamps of all hops in ascending order.
+func (t TraceValue) sorted() []int64 {
+	timestamps := make([]int64, 0, len(t.Traces))
+	for timestamp := range t.Traces {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+	return timestamps
+}
+
+// TraceEncoding selects how the x-trace header is encoded.
+type TraceEncoding int
+
+const (
+	// TraceEncodingJSON encodes the x-trace header as JSON, which all versions of this library can read.
+	TraceEncodingJSON TraceEncoding = iota
+	// TraceEncodingBinary encodes the x-trace header with varint timestamp deltas and back-references to repeated services,
+	// in base64 so the header stays valid UTF-8. It is a fraction of the JSON size,
+	// but only readable by versions of this library that support it.
+	TraceEncodingBinary
+)
+
+// traceBinaryPrefix marks binary encoded x-trace headers and their version, JSON encoded ones always start with '{'.
+const traceBinaryPrefix = "b1:"
+
+// TraceOptions bounds the size of the x-trace header. Once a limit is exceeded, the oldest hops are evicted.
+type TraceOptions struct {
+	// MaxHops is the maximum number of hops kept, 0 means no limit.
+	MaxHops int
+	// MaxBytes is the maximum size of the encoded header, 0 means no limit. The newest hop is always kept.
+	MaxBytes int
+	// Encoding is the encoding of the header.
+	Encoding TraceEncoding
+}
+
+// DefaultTraceOptions keeps the last 32 hops within 2 KiB, encoded as JSON.
+var DefaultTraceOptions = TraceOptions{
+	MaxHops:  32,
+	MaxBytes: 2048,
+	Encoding: TraceEncodingJSON,
+}
+
+// WithTraceLimits bounds the x-trace header to maxHops hops and maxBytes bytes, 0 disables a limit.
+func WithTraceLimits(maxHops, maxBytes int) Option {
+	return func(c *Config) error {
+		if maxHops < 0 || maxBytes < 0 {
+			return fmt.Errorf("invalid trace limits of %d hops and %d bytes", maxHops, maxBytes)
+		}
+		c.Trace.MaxHops = maxHops
+		c.Trace.MaxBytes = maxBytes
+		return nil
+	}
+}
+
+// WithTraceEncoding sets the encoding of the x-trace header.
+// Only use TraceEncodingBinary once all consumers of the produced topics support it.
+func WithTraceEncoding(encoding TraceEncoding) Option {
+	return func(c *Config) error {
+		if encoding != TraceEncodingJSON && encoding != TraceEncodingBinary {
+			return fmt.Errorf("invalid trace encoding %d", encoding)
+		}
+		c.Trace.Encoding = encoding
+		return nil
+	}
+}
+
+// encode evicts the oldest hops of trace until it fits the limits and encodes it.
+func (o TraceOptions) encode(trace TraceValue) (string, error) {
+	timestamps := trace.sorted()
+	if o.MaxHops > 0 && len(timestamps) > o.MaxHops {
+		timestamps = timestamps[len(timestamps)-o.MaxHops:]
+	}
+	for {
+		encoded, err := o.encodeHops(trace, timestamps)
+		if err != nil {
+			return "", err
+		}
+		if o.MaxBytes == 0 || len(encoded) <= o.MaxBytes || len(timestamps) <= 1 {
+			return encoded, nil
+		}
+		timestamps = timestamps[1:]
+	}
+}
+
+func (o TraceOptions) encodeHops(trace TraceValue, timestamps []int64) (string, error) {
+	if o.Encoding == TraceEncodingBinary {
+		return encodeTraceBinary(trace, timestamps), nil
+	}
+	kept := TraceValue{Traces: make(map[int64]string, len(timestamps))}
+	for _, timestamp := range timestamps {
+		kept.Traces[timestamp] = trace.Traces[timestamp]
+	}
+	j, err := json.Marshal(kept)
+	if err != nil {
+		return "", err
+	}
+	return string(j), nil
+}
+
+// encodeTraceBinary writes traceBinaryPrefix followed by the base64 encoding of one entry per hop: the varint difference to the previous
+// timestamp, then the uvarint length of a new service shifted left by one, or the index of an already written
+// service shifted left by one with the lowest bit set, then the bytes of a new service.
+func encodeTraceBinary(trace TraceValue, timestamps []int64) string {
+	var buffer []byte
+	services := make(map[string]uint64)
+	var previous int64
+	for _, timestamp := range timestamps {
+		buffer = binary.AppendVarint(buffer, timestamp-previous)
+		previous = timestamp
+		service := trace.Traces[timestamp]
+		if index, ok := services[service]; ok {
+			buffer = binary.AppendUvarint(buffer, index<<1|1)
+			continue
+		}
+		services[service] = uint64(len(services))
+		buffer = binary.AppendUvarint(buffer, uint64(len(service))<<1)
+		buffer = append(buffer, service...)
+	}
+	return traceBinaryPrefix + base64.RawStdEncoding.EncodeToString(buffer)
+}
+
+var errInvalidTrace = errors.New("invalid x-trace header")
+
+// decodeTrace decodes a JSON or binary encoded x-trace header.
+func decodeTrace(header string) (TraceValue, error) {
+	var traceValue TraceValue
+	if !strings.HasPrefix(header, traceBinaryPrefix) {
+		err := json.Unmarshal([]byte(header), &traceValue)
+		return traceValue, err
+	}
+
+	buffer, err := base64.RawStdEncoding.DecodeString(header[len(traceBinaryPrefix):])
+	if err != nil {
+		return traceValue, errInvalidTrace
+	}
+	traceValue.Traces = make(map[int64]string)
+	var services []string
+	var timestamp int64
+	for len(buffer) > 0 {
+		delta, n := binary.Varint(buffer)
+		if n <= 0 {
+			return traceValue, errInvalidTrace
+		}
+		buffer = buffer[n:]
+		timestamp += delta
+		reference, n := binary.Uvarint(buffer)
+		if n <= 0 {
+			return traceValue, errInvalidTrace
+		}
+		buffer = buffer[n:]
+		if reference&1 == 1 {
+			index := reference >> 1
+			if index >= uint64(len(services)) {
+				return traceValue, errInvalidTrace
+			}
+			traceValue.Traces[timestamp] = services[index]
+			continue
+		}
+		length := reference >> 1
+		if length > uint64(len(buffer)) {
+			return traceValue, errInvalidTrace
+		}
+		service := string(buffer[:length])
+		buffer = buffer[length:]
+		services = append(services, service)
+		traceValue.Traces[timestamp] = service
+	}
+	return traceValue, nil
+}
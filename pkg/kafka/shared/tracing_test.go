@@ -0,0 +1,81 @@
+package shared
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tracedMessage(t *testing.T, hops int) *KafkaMessage {
+	trace := TraceValue{Traces: map[int64]string{}}
+	start := time.Now().Add(-time.Hour).UnixNano()
+	for i := 0; i < hops; i++ {
+		trace.Traces[start+int64(i)*int64(time.Second)] = []string{"factoryinput-1", "kafka-bridge-1"}[i%2]
+	}
+	j, err := json.Marshal(trace)
+	assert.NoError(t, err)
+	return &KafkaMessage{Headers: map[string]string{"x-trace": string(j)}}
+}
+
+func TestAddSXTraceEvictsOldestHops(t *testing.T) {
+	msg := tracedMessage(t, 10)
+	assert.NoError(t, addSXTrace(msg, TraceOptions{MaxHops: 4}))
+	hops := msg.TraceHops()
+	assert.Len(t, hops, 4)
+	assert.Equal(t, "kafka-bridge-1", hops[0].Service, "the 7th of the original hops is the oldest kept")
+	assert.Equal(t, time.Second, hops[1].Duration)
+	assert.Equal(t, "-", hops[3].Service, "the added hop is the newest")
+
+	msg = tracedMessage(t, 100)
+	assert.NoError(t, addSXTrace(msg, TraceOptions{MaxBytes: 256}))
+	assert.LessOrEqual(t, len(msg.Headers["x-trace"]), 256)
+	assert.Equal(t, "-", msg.TraceHops()[len(msg.TraceHops())-1].Service)
+}
+
+func TestToProducerMessageBoundsForwardedTrace(t *testing.T) {
+	msg := tracedMessage(t, 10)
+	hops := msg.TraceHops()
+
+	config, err := NewConfig(Config{}, WithTraceLimits(3, 0), WithTraceEncoding(TraceEncodingBinary))
+	assert.NoError(t, err)
+	m, err := config.ToProducerMessage(msg)
+	assert.NoError(t, err)
+
+	var header string
+	for _, h := range m.Headers {
+		if string(h.Key) == "x-trace" {
+			header = string(h.Value)
+		}
+	}
+	assert.True(t, strings.HasPrefix(header, traceBinaryPrefix))
+	kept := msg.TraceHops()
+	assert.Len(t, kept, 3, "no hop is added")
+	for i, hop := range kept {
+		assert.Equal(t, hops[7+i].Service, hop.Service, "the newest hops are kept")
+		assert.Equal(t, hops[7+i].Time, hop.Time)
+	}
+}
+
+func TestBinaryTraceEncoding(t *testing.T) {
+	msg := tracedMessage(t, 20)
+	hops := msg.TraceHops()
+	jsonSize := len(msg.Headers["x-trace"])
+
+	config, err := NewConfig(Config{}, WithTraceLimits(0, 0), WithTraceEncoding(TraceEncodingBinary))
+	assert.NoError(t, err)
+	assert.NoError(t, config.AddSXTrace(msg))
+	assert.True(t, strings.HasPrefix(msg.Headers["x-trace"], traceBinaryPrefix))
+	assert.Less(t, len(msg.Headers["x-trace"])*3, jsonSize)
+
+	binaryHops := msg.TraceHops()
+	assert.Len(t, binaryHops, 21)
+	assert.Equal(t, hops, binaryHops[:20])
+	assert.True(t, IsInTrace(msg))
+
+	msg.Headers["x-trace"] = traceBinaryPrefix + "AAk"
+	ok, _ := GetSXTrace(msg)
+	assert.False(t, ok)
+	assert.Nil(t, msg.TraceHops())
+}